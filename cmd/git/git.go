@@ -0,0 +1,41 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var gitCMD = &cobra.Command{
+	Use:   "git",
+	Short: "bring the jira references in git repositories along to gitlab",
+}
+
+var contextLogger = log.WithFields(log.Fields{"Command": "Git"})
+
+//GetCommands grab and return commands in this package
+func GetCommands() *cobra.Command {
+
+	//collect the commands in the package
+	addRewriteRefs()
+	return gitCMD
+}
+
+// run executes a git command in repository repo and returns its output
+func run(repo string, args ...string) (string, error) {
+	var out, stderr bytes.Buffer
+
+	c := exec.Command("git", append([]string{"-C", repo}, args...)...)
+	c.Stdout = &out
+	c.Stderr = &stderr
+
+	contextLogger.Debugf("running git %s", strings.Join(args, " "))
+	if err := c.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %s %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out.String(), nil
+}
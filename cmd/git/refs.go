@@ -0,0 +1,76 @@
+package git
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/wianvos/pigmy/cmd/redirects"
+)
+
+// issueKeyPattern matches jira issue keys like PRO-123
+var issueKeyPattern = regexp.MustCompile(`\b[A-Z][A-Z0-9_]+-[0-9]+\b`)
+
+// references resolves jira keys to gitlab issue references
+type references struct {
+	entries map[string]redirects.Entry
+	// path of the gitlab project the repository lives in, its own issues are referenced without it
+	project string
+}
+
+func newReferences(es []redirects.Entry, project string) *references {
+	r := &references{entries: make(map[string]redirects.Entry, len(es)), project: project}
+	for _, e := range es {
+		r.entries[e.Key] = e
+	}
+	return r
+}
+
+// ref returns the gitlab reference for jira key k
+func (r *references) ref(k string) (string, bool) {
+	e, ok := r.entries[k]
+	if !ok {
+		return "", false
+	}
+	if p := e.Path(); p != "" && p != r.project {
+		return fmt.Sprintf("%s#%d", p, e.IID), true
+	}
+	return fmt.Sprintf("#%d", e.IID), true
+}
+
+// keys returns the known jira keys mentioned in message m, each once
+func (r *references) keys(m string) []string {
+	var ks []string
+	seen := make(map[string]bool)
+	for _, k := range issueKeyPattern.FindAllString(m, -1) {
+		if _, ok := r.entries[k]; ok && !seen[k] {
+			seen[k] = true
+			ks = append(ks, k)
+		}
+	}
+	return ks
+}
+
+// rewrite adds the gitlab reference behind every known jira key in message m: PRO-123 becomes PRO-123 (group/project#42).
+// keys that already carry their reference are left alone, so rewriting twice changes nothing
+func (r *references) rewrite(m string) string {
+	var b strings.Builder
+	last := 0
+
+	for _, ix := range issueKeyPattern.FindAllStringIndex(m, -1) {
+		ref, ok := r.ref(m[ix[0]:ix[1]])
+		if !ok {
+			continue
+		}
+		s := fmt.Sprintf(" (%s)", ref)
+		if strings.HasPrefix(m[ix[1]:], s) {
+			continue
+		}
+		b.WriteString(m[last:ix[1]])
+		b.WriteString(s)
+		last = ix[1]
+	}
+	b.WriteString(m[last:])
+
+	return b.String()
+}
@@ -0,0 +1,297 @@
+package git
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/wianvos/pigmy/cmd/redirects"
+)
+
+var mode string
+var mapFile string
+var projects []string
+var gitlabProject string
+var notesRef string
+var output string
+
+// create the command and add it to the gitCMD objects
+func addRewriteRefs() {
+	cmd := &cobra.Command{
+		Use:   "rewrite-refs <repository>",
+		Short: "link the jira keys in the commit messages of a local repository to their gitlab issues",
+		Long: `link the jira keys in the commit messages of a local repository to their gitlab issues.
+
+modes:
+  notes    attach a git note listing the gitlab issues to every commit mentioning a jira key (default)
+  lookup   write a file mapping every commit and jira key to its gitlab issue
+  rewrite  rewrite the commit messages, PRO-123 becomes PRO-123 (group/project#42).
+           this produces a new history, run it on a fresh clone
+
+works offline, the mapping comes from the migration state or a csv written by pigmy redirects --format csv`,
+		Run: runRewriteRefs,
+	}
+
+	cmd.Flags().StringVar(&mode, "mode", "notes", "what to do with the references: notes, lookup or rewrite")
+	cmd.Flags().StringVar(&mapFile, "map", "", "csv redirect map to use instead of the migration state")
+	cmd.Flags().StringSliceVar(&projects, "projects", nil, "jira projects to take the mapping from (defaults to every migrated project)")
+	cmd.Flags().StringVar(&gitlabProject, "gitlab-project", "", "gitlab path (group/project) of the repository, its own issues are referenced as #42")
+	cmd.Flags().StringVar(&notesRef, "notes-ref", "jira", "notes ref to attach the notes to in notes mode")
+	cmd.Flags().StringVar(&output, "output", "", "lookup file to write in lookup mode (defaults to jira-refs.txt in the repository)")
+
+	gitCMD.AddCommand(cmd)
+}
+
+func runRewriteRefs(cmd *cobra.Command, args []string) {
+
+	contextLogger = contextLogger.WithFields(log.Fields{"subcommand": "RewriteRefs"})
+	if len(args) != 1 {
+		contextLogger.Fatal("need the path to a local git repository")
+		os.Exit(2)
+	}
+	repo := args[0]
+
+	if _, err := run(repo, "rev-parse", "--git-dir"); err != nil {
+		fmt.Printf("%s is not a git repository: %s\n", repo, err)
+		os.Exit(2)
+	}
+
+	es, err := loadEntries()
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to load the jira to gitlab mapping")
+		fmt.Printf("unable to load the jira to gitlab mapping: %s\n", err)
+		os.Exit(2)
+	}
+	r := newReferences(es, gitlabProject)
+
+	var n int
+	switch mode {
+	case "notes":
+		n, err = addNotes(repo, r)
+	case "lookup":
+		n, err = writeLookup(repo, r)
+	case "rewrite":
+		n, err = rewriteHistory(repo, r)
+	default:
+		err = fmt.Errorf("unknown mode %s", mode)
+	}
+
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to rewrite references")
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	fmt.Printf("%d commits referencing migrated jira issues processed\n", n)
+}
+
+// loadEntries reads the jira to gitlab mapping from the csv map or the migration state
+func loadEntries() ([]redirects.Entry, error) {
+	if mapFile == "" {
+		return redirects.Collect(projects)
+	}
+
+	f, err := os.Open(mapFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return redirects.ReadCSV(f)
+}
+
+// commit is a commit hash with its message
+type commit struct {
+	hash    string
+	message string
+}
+
+// commits lists every commit reachable from any ref in the repository
+func commits(repo string) ([]commit, error) {
+	out, err := run(repo, "log", "--all", "--format=%H%x1f%B%x1e")
+	if err != nil {
+		return nil, err
+	}
+
+	var cs []commit
+	for _, c := range strings.Split(out, "\x1e") {
+		p := strings.SplitN(strings.TrimLeft(c, "\n"), "\x1f", 2)
+		if len(p) != 2 {
+			continue
+		}
+		cs = append(cs, commit{hash: p[0], message: p[1]})
+	}
+	return cs, nil
+}
+
+// addNotes attaches a note with the gitlab references to every commit mentioning a migrated jira issue
+func addNotes(repo string, r *references) (int, error) {
+	cs, err := commits(repo)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, c := range cs {
+		ks := r.keys(c.message)
+		if len(ks) == 0 {
+			continue
+		}
+
+		var l []string
+		for _, k := range ks {
+			ref, _ := r.ref(k)
+			l = append(l, fmt.Sprintf("%s: %s %s", k, ref, r.entries[k].URL))
+		}
+
+		if _, err := run(repo, "notes", "--ref", notesRef, "add", "-f", "-m", strings.Join(l, "\n"), c.hash); err != nil {
+			return n, err
+		}
+		n = n + 1
+	}
+
+	fmt.Printf("notes added to refs/notes/%s, show them with git log --notes=%s\n", notesRef, notesRef)
+	return n, nil
+}
+
+// writeLookup writes a tab separated file with a line per commit and jira key it mentions
+func writeLookup(repo string, r *references) (int, error) {
+	cs, err := commits(repo)
+	if err != nil {
+		return 0, err
+	}
+
+	o := output
+	if o == "" {
+		o = filepath.Join(repo, "jira-refs.txt")
+	}
+	f, err := os.Create(o)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "# commit\tjira key\tgitlab reference\tgitlab url")
+
+	n := 0
+	for _, c := range cs {
+		ks := r.keys(c.message)
+		for _, k := range ks {
+			ref, _ := r.ref(k)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.hash, k, ref, r.entries[k].URL)
+		}
+		if len(ks) != 0 {
+			n = n + 1
+		}
+	}
+
+	fmt.Printf("lookup written to %s\n", o)
+	return n, w.Flush()
+}
+
+// rewriteHistory pipes the repository through git fast-export and fast-import, rewriting the commit messages on the way
+func rewriteHistory(repo string, r *references) (int, error) {
+	exp := exec.Command("git", "-C", repo, "fast-export", "--all", "--signed-tags=strip", "--tag-of-filtered-object=rewrite")
+	imp := exec.Command("git", "-C", repo, "fast-import", "--force", "--quiet")
+	exp.Stderr = os.Stderr
+	imp.Stderr = os.Stderr
+
+	out, err := exp.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+	in, err := imp.StdinPipe()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := imp.Start(); err != nil {
+		return 0, err
+	}
+	if err := exp.Start(); err != nil {
+		in.Close()
+		imp.Wait()
+		return 0, err
+	}
+
+	w := bufio.NewWriter(in)
+	n, ferr := filterStream(bufio.NewReader(out), w, r)
+	if ferr == nil {
+		ferr = w.Flush()
+	}
+	in.Close()
+
+	eerr := exp.Wait()
+	ierr := imp.Wait()
+	switch {
+	case ferr != nil:
+		return n, ferr
+	case eerr != nil:
+		return n, fmt.Errorf("git fast-export: %s", eerr)
+	case ierr != nil:
+		return n, fmt.Errorf("git fast-import: %s", ierr)
+	}
+
+	fmt.Println("history rewritten, push with --force --all to replace the remote history")
+	return n, nil
+}
+
+// filterStream copies a fast-export stream, rewriting every commit message.
+// returns the number of commit messages that changed
+func filterStream(rd *bufio.Reader, w io.Writer, r *references) (int, error) {
+	n := 0
+	inCommit := false
+
+	for {
+		l, err := rd.ReadString('\n')
+		if err == io.EOF && l == "" {
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+
+		if strings.HasPrefix(l, "commit ") {
+			inCommit = true
+		}
+
+		if !strings.HasPrefix(l, "data ") {
+			if _, err := io.WriteString(w, l); err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		s, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(l, "data ")))
+		if err != nil {
+			return n, fmt.Errorf("unexpected fast-export data line %q", l)
+		}
+		d := make([]byte, s)
+		if _, err := io.ReadFull(rd, d); err != nil {
+			return n, err
+		}
+
+		// the first data of a commit is its message, whatever follows is file content
+		if inCommit {
+			m := r.rewrite(string(d))
+			if m != string(d) {
+				n = n + 1
+			}
+			d = []byte(m)
+			inCommit = false
+		}
+
+		if _, err := fmt.Fprintf(w, "data %d\n", len(d)); err != nil {
+			return n, err
+		}
+		if _, err := w.Write(d); err != nil {
+			return n, err
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// archiveTarget writes projects as a static archive of markdown files, for projects that are retired
+// rather than migrated. every project gets a directory with an index, an issues directory holding
+// a file with front matter per issue and an attachments directory with a directory per issue
+type archiveTarget struct {
+	Dir string
+}
+
+// archiveTimeFormat is how the archive shows dates to humans
+const archiveTimeFormat = "2006-01-02 15:04"
+
+// Migrate writes the issues of project p to the archive and rebuilds its index
+func (a archiveTarget) Migrate(p *Project) error {
+	d := filepath.Join(a.Dir, p.Name)
+	for _, sd := range []string{"issues", "attachments"} {
+		if err := os.MkdirAll(filepath.Join(d, sd), 0770); err != nil {
+			return err
+		}
+	}
+
+	contextLogger := contextLogger.WithField("project", p.Name)
+	fmt.Fprintf(out, "writing archive of %s to %s\n", p.Name, d)
+	bar := newProgress(len(p.Issues))
+	s := 0
+	e := p.unfetched
+
+	for x := range p.Issues {
+		if p.canceled() != nil {
+			break
+		}
+		bar.Add(1)
+		if err := a.writeIssue(p, &p.Issues[x]); err != nil {
+			e = e + 1
+			contextLogger.WithError(err).Errorf("unable to archive jira issue %s", p.Issues[x].JiraKey)
+		} else {
+			s = s + 1
+		}
+	}
+
+	p.Migrated = s
+	p.Failed = e
+	if err := a.writeIndex(p); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\nproject archived. %d issues archived succesfully. %d errors encountered\n", s, e)
+	return nil
+}
+
+// writeIssue writes issue i as issues/<name>.md, its attachements go to attachments/<name>.
+// the name is the key of the issue, see archiveName
+func (a archiveTarget) writeIssue(p *Project, i *Issue) error {
+	contextLogger := contextLogger.WithFields(log.Fields{"JiraIssueID": i.JiraID})
+	d := filepath.Join(a.Dir, p.Name)
+
+	var b bytes.Buffer
+	fmt.Fprintln(&b, "---")
+	fmt.Fprintf(&b, "key: %s\n", strconv.Quote(i.JiraKey))
+	fmt.Fprintf(&b, "id: %s\n", strconv.Quote(i.JiraID))
+	fmt.Fprintf(&b, "title: %s\n", strconv.Quote(strings.TrimPrefix(i.Title, i.JiraKey+":")))
+	fmt.Fprintf(&b, "status: %s\n", strconv.Quote(i.Status))
+	fmt.Fprintf(&b, "creator: %s\n", strconv.Quote(i.CreatorID))
+	fmt.Fprintf(&b, "assignee: %s\n", strconv.Quote(i.Assignee))
+	fmt.Fprintf(&b, "labels: %s\n", quoteList(i.JiraLabels))
+	fmt.Fprintf(&b, "fixVersions: %s\n", quoteList(i.FixVersions))
+	fmt.Fprintf(&b, "created: %s\n", i.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "updated: %s\n", i.UpdatedAt.Format(time.RFC3339))
+	fmt.Fprintln(&b, "---")
+
+	fmt.Fprintf(&b, "\n# %s\n\n", i.Title)
+	fmt.Fprintf(&b, "*%s* opened by %s on %s", i.Status, p.displayName(i.CreatorID), i.CreatedAt.Format(archiveTimeFormat))
+	if i.Assignee != "" {
+		fmt.Fprintf(&b, ", assigned to %s", p.displayName(i.Assignee))
+	}
+	fmt.Fprintf(&b, "\n\n%s\n", a.translate(p, i.Description))
+
+	if len(i.Comments) != 0 {
+		fmt.Fprintf(&b, "\n## Comments\n")
+		for _, c := range i.Comments {
+			fmt.Fprintf(&b, "\n### %s, %s\n\n%s\n", p.displayName(c.CreatorID), c.CreatedAt.Format(archiveTimeFormat), a.translate(p, c.Body))
+		}
+	}
+
+	if len(i.Attachements) != 0 {
+		fmt.Fprintf(&b, "\n## Attachments\n\n")
+		ad := filepath.Join(d, "attachments", archiveName(i.JiraKey))
+		if err := os.MkdirAll(ad, 0770); err != nil {
+			return err
+		}
+		for _, at := range i.Attachements {
+			n := filepath.Base(at.FileName)
+			if err := moveFile(at.FileName, filepath.Join(ad, n)); err != nil {
+				contextLogger.WithError(err).Errorf("unable to archive attachement %s", n)
+				continue
+			}
+			u := url.URL{Path: fmt.Sprintf("../attachments/%s/%s", archiveName(i.JiraKey), n)}
+			fmt.Fprintf(&b, "- [%s](%s), %s, %s\n", n, u.String(), p.displayName(at.CreatorID), at.CreatedAt.Format(archiveTimeFormat))
+		}
+	}
+
+	return ioutil.WriteFile(filepath.Join(d, "issues", archiveName(i.JiraKey)+".md"), b.Bytes(), 0660)
+}
+
+// archiveName is the file name of the issue with key k. github keys, owner/repo#number, would
+// otherwise end up in a directory and a url fragment
+func archiveName(k string) string {
+	return strings.NewReplacer("/", "_", "#", "-").Replace(k)
+}
+
+// translate converts jira markup the way the gitlab target does, issue keys become links
+// to the issues in the archive
+func (a archiveTarget) translate(p *Project, t string) string {
+	link := func(k, pk string) string {
+		if pk == p.Name {
+			return fmt.Sprintf("[%s](%s.md)", k, archiveName(k))
+		}
+		if fi, err := os.Stat(filepath.Join(a.Dir, pk, "issues")); err == nil && fi.IsDir() {
+			return fmt.Sprintf("[%s](../../%s/issues/%s.md)", k, pk, archiveName(k))
+		}
+		return k
+	}
+	t = githubKeyPattern.ReplaceAllStringFunc(translateText(t), func(k string) string {
+		return link(k, k[strings.Index(k, "/")+1:strings.Index(k, "#")])
+	})
+	return issueKeyPattern.ReplaceAllStringFunc(t, func(k string) string {
+		return link(k, k[:strings.LastIndex(k, "-")])
+	})
+}
+
+// displayName returns the name of jira user u as the project knows it
+func (p *Project) displayName(u string) string {
+	for _, x := range p.Users {
+		if x.Username == u && x.Name != "" {
+			return x.Name
+		}
+	}
+	return u
+}
+
+// writeIndex rebuilds index.md from the front matter of every issue in the archive,
+// so issues archived by earlier runs are listed too
+func (a archiveTarget) writeIndex(p *Project) error {
+	d := filepath.Join(a.Dir, p.Name)
+	fs, err := filepath.Glob(filepath.Join(d, "issues", "*.md"))
+	if err != nil {
+		return err
+	}
+
+	var is []map[string]string
+	for _, f := range fs {
+		fm, err := frontMatter(f)
+		if err != nil {
+			contextLogger.WithError(err).Errorf("unable to read %s", f)
+			continue
+		}
+		is = append(is, fm)
+	}
+	// PRO-9 before PRO-10, owner/repo#9 before owner/repo#10
+	sort.Slice(is, func(x, y int) bool {
+		nx, _ := strconv.Atoi(is[x]["key"][strings.LastIndexAny(is[x]["key"], "-#")+1:])
+		ny, _ := strconv.Atoi(is[y]["key"][strings.LastIndexAny(is[y]["key"], "-#")+1:])
+		return nx < ny
+	})
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "---\ntitle: %s\n---\n\n", strconv.Quote(p.Name))
+	fmt.Fprintf(&b, "# %s\n\nArchive of jira project %s, %d issues, written %s.\n\n", p.Name, p.Name, len(is), time.Now().Format(archiveTimeFormat))
+	fmt.Fprintln(&b, "| Key | Title | Status | Assignee | Updated |")
+	fmt.Fprintln(&b, "|-----|-------|--------|----------|---------|")
+	cell := strings.NewReplacer("|", `\|`, "\n", " ")
+	for _, i := range is {
+		u, _ := time.Parse(time.RFC3339, i["updated"])
+		fmt.Fprintf(&b, "| [%s](issues/%s.md) | %s | %s | %s | %s |\n", i["key"], archiveName(i["key"]), cell.Replace(i["title"]), cell.Replace(i["status"]), cell.Replace(i["assignee"]), u.Format(archiveTimeFormat))
+	}
+
+	return ioutil.WriteFile(filepath.Join(d, "index.md"), b.Bytes(), 0660)
+}
+
+// frontMatter reads the single valued front matter fields of archived issue file f
+func frontMatter(f string) (map[string]string, error) {
+	fh, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	fm := make(map[string]string)
+	sc := bufio.NewScanner(fh)
+	if !sc.Scan() || sc.Text() != "---" {
+		return nil, fmt.Errorf("no front matter")
+	}
+	for sc.Scan() && sc.Text() != "---" {
+		kv := strings.SplitN(sc.Text(), ": ", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v := kv[1]
+		if uv, err := strconv.Unquote(v); err == nil {
+			v = uv
+		}
+		fm[kv[0]] = v
+	}
+	return fm, sc.Err()
+}
+
+func quoteList(vs []string) string {
+	q := make([]string, 0, len(vs))
+	for _, v := range vs {
+		q = append(q, strconv.Quote(v))
+	}
+	return "[" + strings.Join(q, ", ") + "]"
+}
+
+// moveFile moves file f to t, copying when a rename can't cross file systems
+func moveFile(f, t string) error {
+	if err := os.Rename(f, t); err == nil {
+		return nil
+	}
+	in, err := os.Open(f)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(t)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(f)
+}
@@ -0,0 +1,211 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	utils "github.com/wianvos/pigmy/cmd/utils"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// authorship modes
+const (
+	// authorshipSudo posts as the original author using sudo, it needs an admin token. gitlab ignores the
+	// creation date of what non-admins post, their work is posted as the token owner with the header instead
+	authorshipSudo = "sudo"
+	// authorshipHeader posts as the token owner, or the author's own token when there is one,
+	// and names the original author in a header
+	authorshipHeader = "header"
+	// authorshipImpersonation posts with an impersonation token minted for the author, it needs an admin token
+	authorshipImpersonation = "impersonation"
+)
+
+// jiraTimeFormat is how jira formats the timestamps it hands out as strings
+const jiraTimeFormat = "2006-01-02T15:04:05.000-0700"
+
+const defaultAuthorHeader = "*Originally created by @{{.User}} on {{.Date}} in [{{.Key}}]({{.URL}})*"
+
+// authorship options
+var (
+	authorship   string
+	authorHeader string
+	authorTokens string
+)
+
+var (
+	// authorMode is the authorship mode in effect, sudo until loadAuthorship says otherwise
+	authorMode     string
+	headerTemplate *template.Template
+	userTokens     map[string]string
+	userClients    = make(map[string]*gitlab.Client)
+	authorOnce     sync.Once
+	authorErr      error
+	userClientsMu  sync.Mutex
+)
+
+// addAuthorshipFlags registers the authorship flags on the given command
+func addAuthorshipFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&authorship, "authorship", authorshipSudo, "how to post as the original author: sudo or impersonation (both need an admin token) or header")
+	cmd.PersistentFlags().StringVar(&authorHeader, "author-header", defaultAuthorHeader, "template of the header naming the original author of whatever is not posted as that author, fields: User, Date, Key, URL")
+	cmd.PersistentFlags().StringVar(&authorTokens, "author-tokens", "", "json file mapping jira usernames to their own gitlab tokens, used in header mode")
+
+	// the config file can set these too
+	viper.BindPFlag("authorship", cmd.PersistentFlags().Lookup("authorship"))
+	viper.BindPFlag("authorHeader", cmd.PersistentFlags().Lookup("author-header"))
+	viper.BindPFlag("authorTokens", cmd.PersistentFlags().Lookup("author-tokens"))
+}
+
+// authorHeaderData feeds the header template
+type authorHeaderData struct {
+	User string
+	Date string
+	Key  string
+	URL  string
+}
+
+// loadAuthorship checks the authorship flags and reads the token file, once
+func loadAuthorship() error {
+	authorOnce.Do(func() {
+		// targets other than gitlab may name authors whatever the mode
+		h := viper.GetString("authorHeader")
+		if h == "" {
+			h = defaultAuthorHeader
+		}
+		if headerTemplate, authorErr = template.New("header").Parse(h); authorErr != nil {
+			authorErr = fmt.Errorf("unable to parse the author header: %s", authorErr)
+			return
+		}
+
+		m := viper.GetString("authorship")
+		switch m {
+		case "", authorshipSudo:
+			return
+		case authorshipHeader, authorshipImpersonation:
+		default:
+			authorErr = fmt.Errorf("unknown authorship mode %s", m)
+			return
+		}
+
+		authorMode = m
+		f := viper.GetString("authorTokens")
+		if f == "" {
+			return
+		}
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			authorErr = err
+			return
+		}
+		if err := json.Unmarshal(b, &userTokens); err != nil {
+			authorErr = fmt.Errorf("unable to read the author tokens: %s", err)
+		}
+	})
+	return authorErr
+}
+
+// author returns the client and request options to post something written by jira user u with.
+// in sudo mode only admins are sudoed to, the rest is posted as the token owner under the author header
+func author(u string) (*gitlab.Client, []gitlab.OptionFunc) {
+	switch authorMode {
+	case authorshipHeader:
+	case authorshipImpersonation:
+		if c := impersonate(u); c != nil {
+			return c, nil
+		}
+		return utils.GetGitlabClient(), nil
+	default:
+		// gitlab only takes the original dates from admins, whoever else wrote it is named in the header
+		if backdates(u) {
+			return utils.GetGitlabClient(), []gitlab.OptionFunc{gitlab.WithSudo(u)}
+		}
+		return utils.GetGitlabClient(), nil
+	}
+
+	if t, ok := userTokens[u]; ok {
+		userClientsMu.Lock()
+		defer userClientsMu.Unlock()
+		if c, ok := userClients[u]; ok {
+			return c, nil
+		}
+		c := gitlab.NewClient(nil, t)
+		c.SetBaseURL(viper.GetString("gitlabURL"))
+		userClients[u] = c
+		return c, nil
+	}
+	return utils.GetGitlabClient(), nil
+}
+
+// attribute prefixes text written by jira user u at t in issue i with the author header,
+// when it isn't posted as u to begin with
+func attribute(text, u string, t time.Time, i *Issue) string {
+	if postsAs(u) {
+		return text
+	}
+	return withHeader(text, u, t, i)
+}
+
+// withHeader prefixes text written by jira user u at t in issue i with the author header
+func withHeader(text, u string, t time.Time, i *Issue) string {
+	d := authorHeaderData{
+		User: u,
+		Key:  i.JiraKey,
+		URL:  i.URL,
+	}
+	if d.URL == "" {
+		d.URL = fmt.Sprintf("%s/browse/%s", strings.TrimRight(viper.GetString("jiraURL"), "/"), i.JiraKey)
+	}
+	if !t.IsZero() {
+		d.Date = t.Format("2006-01-02 15:04")
+	}
+
+	var b bytes.Buffer
+	if err := headerTemplate.Execute(&b, d); err != nil {
+		contextLogger.WithError(err).Error("unable to render the author header")
+		return text
+	}
+	return b.String() + "\n\n" + text
+}
+
+// postsAs tells if things written by jira user u are posted as u
+func postsAs(u string) bool {
+	switch authorMode {
+	case authorshipHeader:
+		_, ok := userTokens[u]
+		return ok
+	case authorshipImpersonation:
+		return impersonate(u) != nil
+	}
+	return backdates(u)
+}
+
+// backdates tells if gitlab user u may set the creation date of what they post, only admins may
+func backdates(u string) bool {
+	au := gitlabUserGet(u)
+	return au != nil && au.IsAdmin
+}
+
+// jiraTime parses a jira timestamp, the zero time when it can't
+func jiraTime(s string) time.Time {
+	t, _ := time.Parse(jiraTimeFormat, s)
+	return t
+}
+
+// issueBody renders the gitlab description of issue i
+func (p *Project) issueBody(i *Issue) string {
+	d, _ := p.translate(i.Description)
+	return attribute(d, i.CreatorID, i.CreatedAt, i)
+}
+
+// commentBody renders the gitlab note of comment c on issue i
+func (p *Project) commentBody(i *Issue, c Comment) string {
+	b, _ := p.translate(c.Body)
+	return attribute(b, c.CreatorID, c.CreatedAt, i)
+}
@@ -0,0 +1,183 @@
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var concurrency int
+
+// Manifest lists the projects a batch migrates, with the settings they share and their own overrides
+type Manifest struct {
+	Concurrency int             `json:"concurrency"`
+	Defaults    ProjectConfig   `json:"defaults"`
+	Projects    []ProjectConfig `json:"projects"`
+}
+
+// batchResult is the outcome of a single project in a batch
+type batchResult struct {
+	Project  string
+	Migrated int
+	Failed   int
+	Duration time.Duration
+	Err      error
+}
+
+//create the command and add it to the migrateCMD objects
+func addBatch() {
+	cmd := &cobra.Command{
+		Use:   "batch <manifest.json>",
+		Short: "migrate every project listed in a manifest",
+		Run:   runBatch,
+	}
+
+	cmd.Flags().IntVar(&concurrency, "concurrency", 0, "number of projects to migrate at the same time (overrides the manifest, defaults to 1)")
+
+	migrateCMD.AddCommand(cmd)
+}
+
+func runBatch(cmd *cobra.Command, args []string) {
+
+	contextLogger = contextLogger.WithFields(log.Fields{"subcommand": "Batch"})
+	if len(args) != 1 {
+		contextLogger.Fatal("need a manifest to migrate")
+		os.Exit(2)
+	}
+
+	m, err := readManifest(args[0])
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to read manifest")
+		fmt.Printf("unable to read manifest %s: %s\n", args[0], err)
+		os.Exit(2)
+	}
+
+	n := concurrency
+	if n == 0 {
+		n = m.Concurrency
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	if _, err := BeginRun("migrate batch " + args[0]); err != nil {
+		contextLogger.WithError(err).Error("unable to record the run")
+		fmt.Printf("unable to record the run: %s .. exiting\n", err)
+		os.Exit(2)
+	}
+
+	fmt.Printf("migrating %d projects, %d at a time\n", len(m.Projects), n)
+
+	results := make([]batchResult, len(m.Projects))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for x := range jobs {
+				results[x] = migrateBatchProject(m.Projects[x].merge(m.Defaults))
+			}
+		}()
+	}
+	for x := range m.Projects {
+		jobs <- x
+	}
+	close(jobs)
+	wg.Wait()
+
+	if failed := printSummary(results); failed != 0 {
+		exit(1)
+	}
+	EndRun()
+}
+
+// readManifest reads and validates a batch manifest
+func readManifest(f string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(f)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+
+	if len(m.Projects) == 0 {
+		return nil, fmt.Errorf("no projects listed")
+	}
+	seen := make(map[string]bool)
+	for x, p := range m.Projects {
+		if p.Name == "" {
+			return nil, fmt.Errorf("project %d has no name", x+1)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("project %s is listed twice", p.Name)
+		}
+		seen[p.Name] = true
+	}
+
+	return m, nil
+}
+
+// migrateBatchProject runs a full project migration, the way migrate project does
+func migrateBatchProject(c ProjectConfig) batchResult {
+	contextLogger := contextLogger.WithFields(log.Fields{"Project": c.Name})
+	r := batchResult{Project: c.Name}
+	start := time.Now()
+
+	p, err := FetchProject(context.Background(), c)
+	if err == nil {
+		err = p.MigrateProject()
+	}
+	if err == nil {
+		p.WriteBackIfEnabled()
+	}
+
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to migrate project")
+	}
+
+	r.Migrated = p.Migrated
+	r.Failed = p.Failed
+	r.Duration = time.Since(start)
+	r.Err = err
+	return r
+}
+
+// printSummary prints the combined outcome of the batch and returns the number of projects that did not migrate cleanly
+func printSummary(rs []batchResult) int {
+	fmt.Println("\nbatch summary")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PROJECT\tMIGRATED\tFAILED\tDURATION\tRESULT")
+
+	f := 0
+	var tm, tf int
+	for _, r := range rs {
+		res := "ok"
+		if r.Err != nil {
+			res = r.Err.Error()
+		}
+		if r.Err != nil || r.Failed != 0 {
+			f = f + 1
+		}
+		tm = tm + r.Migrated
+		tf = tf + r.Failed
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", r.Project, r.Migrated, r.Failed, r.Duration.Round(time.Second), res)
+	}
+	fmt.Fprintf(w, "TOTAL\t%d\t%d\t\t%d of %d projects failed\n", tm, tf, f, len(rs))
+	w.Flush()
+
+	return f
+}
@@ -0,0 +1,183 @@
+package migrate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+	utils "github.com/wianvos/pigmy/cmd/utils"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// ProjectConfig holds the settings of a single project migration
+type ProjectConfig struct {
+	// Name is the key of the jira project
+	Name string `json:"name"`
+	// Target is the name of the gitlab project, defaults to Name
+	Target string `json:"target,omitempty"`
+	// Project is the id or full path (namespace/project) of an existing gitlab project to migrate to
+	Project string `json:"project,omitempty"`
+	// Namespace is the full path of the gitlab group a new project is created in
+	Namespace string `json:"namespace,omitempty"`
+	// Path of a new gitlab project, gitlab derives it from the name when empty
+	Path string `json:"path,omitempty"`
+	// Visibility of a new gitlab project: private, internal or public
+	Visibility string `json:"visibility,omitempty"`
+	// Description of a new gitlab project
+	Description string `json:"description,omitempty"`
+	// IssueTemplates are committed to a new gitlab project, template name to local markdown file
+	IssueTemplates map[string]string `json:"issueTemplates,omitempty"`
+	// features of a new gitlab project, gitlab defaults apply when not set
+	IssuesEnabled        *bool `json:"issuesEnabled,omitempty"`
+	WikiEnabled          *bool `json:"wikiEnabled,omitempty"`
+	MergeRequestsEnabled *bool `json:"mergeRequestsEnabled,omitempty"`
+	// bring the description, lead (as maintainer) and avatar of the jira project along to a new gitlab project
+	ImportDescription bool `json:"importDescription,omitempty"`
+	ImportLead        bool `json:"importLead,omitempty"`
+	ImportAvatar      bool `json:"importAvatar,omitempty"`
+	// JQL selects the issues to migrate, defaults to the entire project. a default jql is and-ed onto the project
+	JQL string `json:"jql,omitempty"`
+	// Labels renames jira labels to gitlab labels
+	Labels map[string]string `json:"labels,omitempty"`
+	// Statuses gives issues in a jira status a gitlab label
+	Statuses map[string]string `json:"statuses,omitempty"`
+	// Members maps jira project roles to gitlab access levels
+	Members *MembershipConfig `json:"members,omitempty"`
+	// GithubArchive is a github migration archive (directory or .tar.gz) to take the issues of
+	// repository Name from instead of jira
+	GithubArchive string `json:"githubArchive,omitempty"`
+}
+
+// configDefaults returns the project section of the config file, the defaults for everything not passed as a flag
+func configDefaults() (ProjectConfig, error) {
+	d := ProjectConfig{}
+	err := viper.UnmarshalKey("project", &d)
+	return d, err
+}
+
+// merge fills the settings left empty from defaults d
+func (c ProjectConfig) merge(d ProjectConfig) ProjectConfig {
+	if c.Target == "" {
+		c.Target = d.Target
+	}
+	if c.Project == "" {
+		c.Project = d.Project
+	}
+	if c.Namespace == "" {
+		c.Namespace = d.Namespace
+	}
+	if c.Path == "" {
+		c.Path = d.Path
+	}
+	if c.Visibility == "" {
+		c.Visibility = d.Visibility
+	}
+	if c.Description == "" {
+		c.Description = d.Description
+	}
+	if c.IssuesEnabled == nil {
+		c.IssuesEnabled = d.IssuesEnabled
+	}
+	if c.WikiEnabled == nil {
+		c.WikiEnabled = d.WikiEnabled
+	}
+	if c.MergeRequestsEnabled == nil {
+		c.MergeRequestsEnabled = d.MergeRequestsEnabled
+	}
+	c.ImportDescription = c.ImportDescription || d.ImportDescription
+	c.ImportLead = c.ImportLead || d.ImportLead
+	c.ImportAvatar = c.ImportAvatar || d.ImportAvatar
+	c.IssueTemplates = mergeMap(d.IssueTemplates, c.IssueTemplates)
+	if c.JQL == "" && d.JQL != "" {
+		// a default jql is shared by every project, it only narrows down the issues of this one
+		base, order := splitOrderBy(d.JQL)
+		c.JQL = fmt.Sprintf("project = %s", quoteJQL(c.Name))
+		if base != "" {
+			c.JQL = fmt.Sprintf("%s AND (%s)", c.JQL, base)
+		}
+		c.JQL = strings.TrimSpace(c.JQL + " " + order)
+	}
+	c.Labels = mergeMap(d.Labels, c.Labels)
+	c.Statuses = mergeMap(d.Statuses, c.Statuses)
+	if c.Members == nil {
+		c.Members = d.Members
+	}
+	if c.GithubArchive == "" {
+		c.GithubArchive = d.GithubArchive
+	}
+	return c
+}
+
+func mergeMap(d, o map[string]string) map[string]string {
+	if len(d) == 0 {
+		return o
+	}
+	m := make(map[string]string)
+	for k, v := range d {
+		m[k] = v
+	}
+	for k, v := range o {
+		m[k] = v
+	}
+	return m
+}
+
+// target returns the name of the gitlab project to migrate to
+func (c ProjectConfig) target() string {
+	if c.Target != "" {
+		return c.Target
+	}
+	return c.Name
+}
+
+// visibility returns the visibility for a new gitlab project, public unless configured otherwise
+func (c ProjectConfig) visibility() (gitlab.VisibilityValue, error) {
+	switch v := gitlab.VisibilityValue(c.Visibility); v {
+	case "":
+		return gitlab.PublicVisibility, nil
+	case gitlab.PrivateVisibility, gitlab.InternalVisibility, gitlab.PublicVisibility:
+		return v, nil
+	}
+	return "", fmt.Errorf("unknown visibility %s", c.Visibility)
+}
+
+// labels returns the gitlab labels for an issue: its jira labels renamed through the label mapping,
+// plus the label the status mapping gives its status. without any mappings the issue keeps its labels
+func (c ProjectConfig) labels(i Issue) []string {
+	if len(c.Labels) == 0 && len(c.Statuses) == 0 {
+		return i.Labels
+	}
+
+	var l []string
+	for _, jl := range i.JiraLabels {
+		if gl, ok := c.Labels[jl]; ok {
+			jl = gl
+		}
+		if jl != "" {
+			l = append(l, jl)
+		}
+	}
+	if sl, ok := c.Statuses[i.Status]; ok && sl != "" {
+		l = append(l, sl)
+	}
+	return l
+}
+
+// namespaceID resolves the namespace path to its gitlab id
+func (c ProjectConfig) namespaceID() (*int, error) {
+	if c.Namespace == "" {
+		return nil, nil
+	}
+
+	glc := utils.GetGitlabClient()
+	ns, _, err := glc.Namespaces.SearchNamespace(c.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range ns {
+		if n.FullPath == c.Namespace {
+			return &n.ID, nil
+		}
+	}
+	return nil, fmt.Errorf("namespace %s not found in gitlab", c.Namespace)
+}
@@ -0,0 +1,217 @@
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+	jira "github.com/wianvos/go-jira"
+	"github.com/wianvos/pigmy/cmd/store"
+	utils "github.com/wianvos/pigmy/cmd/utils"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// templateBranch is where the issue templates of a new project are committed when gitlab reports no
+// default branch, older versions leave it empty until the first commit
+const templateBranch = "master"
+
+// createProject creates the gitlab project the way the project config describes it
+func (p *Project) createProject() (*gitlab.Project, error) {
+	c := p.Config
+	glc := utils.GetGitlabClient()
+
+	vv, err := c.visibility()
+	if err != nil {
+		return nil, err
+	}
+	ns, err := c.namespaceID()
+	if err != nil {
+		return nil, err
+	}
+
+	var jp *jira.Project
+	if c.ImportDescription || c.ImportLead || c.ImportAvatar {
+		jlc := utils.GetJiraClient()
+		if jp, _, err = jlc.Project.Get(p.Name); err != nil {
+			return nil, fmt.Errorf("unable to retrieve jira project: %s", err)
+		}
+	}
+
+	tn := c.target()
+	o := &gitlab.CreateProjectOptions{
+		Name:                 &tn,
+		NamespaceID:          ns,
+		Visibility:           &vv,
+		IssuesEnabled:        c.IssuesEnabled,
+		WikiEnabled:          c.WikiEnabled,
+		MergeRequestsEnabled: c.MergeRequestsEnabled,
+	}
+	if c.Path != "" {
+		o.Path = &c.Path
+	}
+	d := c.Description
+	if d == "" && c.ImportDescription {
+		d = jp.Description
+	}
+	if d != "" {
+		o.Description = &d
+	}
+
+	t := time.Now()
+	gp, _, err := glc.Projects.CreateProject(o)
+	if err != nil {
+		journal(store.ActionCreate, &store.Object{Kind: store.ObjectProject, Name: tn, JiraProject: p.Name}, t, err)
+		return nil, err
+	}
+	journal(store.ActionCreate, &store.Object{Kind: store.ObjectProject, ProjectID: gp.ID, Name: gp.PathWithNamespace, JiraProject: p.Name}, t, nil)
+	contextLogger := contextLogger.WithField("project", gp.PathWithNamespace)
+	contextLogger.Info("project created")
+
+	// whatever goes wrong from here on leaves a usable project behind, so we only complain
+	for n, f := range c.IssueTemplates {
+		if err := addIssueTemplate(gp, n, f); err != nil {
+			contextLogger.WithError(err).Errorf("unable to add issue template %s", n)
+		}
+	}
+
+	if c.ImportAvatar && jp.AvatarUrls.Four8X48 != "" {
+		if err := copyAvatar(gp.ID, jp.AvatarUrls.Four8X48); err != nil {
+			contextLogger.WithError(err).Error("unable to copy the jira project avatar")
+		}
+	}
+
+	if c.ImportLead && jp.Lead.Name != "" {
+		if err := p.addLead(gp.ID, jp.Lead.Name); err != nil {
+			contextLogger.WithError(err).Errorf("unable to add project lead %s", jp.Lead.Name)
+		}
+	}
+
+	return gp, nil
+}
+
+// addLead makes jira project lead n maintainer of gitlab project pid
+func (p *Project) addLead(pid int, n string) error {
+	u, err := jiraGetUser(n)
+	if err != nil {
+		return err
+	}
+	// load the role based access levels first, the lead outranks them
+	if _, err := p.accessLevel(u.Username); err != nil {
+		return err
+	}
+	p.access[u.Username] = gitlab.MaintainerPermissions
+	p.Pid = pid
+	return u.Create(p)
+}
+
+// addIssueTemplate commits local markdown file f as issue template n to the default branch of project gp
+func addIssueTemplate(gp *gitlab.Project, n, f string) error {
+	glc := utils.GetGitlabClient()
+
+	b, err := ioutil.ReadFile(f)
+	if err != nil {
+		return err
+	}
+
+	br := gp.DefaultBranch
+	if br == "" {
+		br = templateBranch
+	}
+	ct := string(b)
+	cm := fmt.Sprintf("Add %s issue template", n)
+	_, _, err = glc.RepositoryFiles.CreateFile(gp.ID, fmt.Sprintf(".gitlab/issue_templates/%s.md", n), &gitlab.CreateFileOptions{
+		Branch:        &br,
+		Content:       &ct,
+		CommitMessage: &cm,
+	})
+	return err
+}
+
+// copyAvatar downloads the jira avatar and sets it on the gitlab project. the gitlab client
+// can't upload avatars, so the multipart request is done by hand
+func copyAvatar(pid int, avatarURL string) error {
+	jlc := utils.GetJiraClient()
+
+	req, err := jlc.NewRequest("GET", avatarURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := jlc.Do(req, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("avatar", filepath.Base(avatarFileName(resp.Header.Get("Content-Type"))))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, resp.Body); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	glc := utils.GetGitlabClient()
+	u := fmt.Sprintf("%sprojects/%d", glc.BaseURL().String(), pid)
+	greq, err := http.NewRequest("PUT", u, &body)
+	if err != nil {
+		return err
+	}
+	greq.Header.Set("Content-Type", mw.FormDataContentType())
+	greq.Header.Set("PRIVATE-TOKEN", viper.GetString("gitlabToken"))
+
+	gresp, err := http.DefaultClient.Do(greq)
+	if err != nil {
+		return err
+	}
+	gresp.Body.Close()
+	if gresp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gitlab refused the avatar: %s", gresp.Status)
+	}
+	return nil
+}
+
+// avatarFileName gives the avatar a name gitlab accepts, it checks the extension
+func avatarFileName(ct string) string {
+	switch ct {
+	case "image/jpeg":
+		return "avatar.jpg"
+	case "image/gif":
+		return "avatar.gif"
+	case "image/svg+xml":
+		return "avatar.svg"
+	}
+	return "avatar.png"
+}
+
+// projectLabels returns the labels of project pid by name, nil when they can't be listed
+func projectLabels(pid int) map[string]*gitlab.Label {
+	glc := utils.GetGitlabClient()
+
+	ls := make(map[string]*gitlab.Label)
+	o := &gitlab.ListLabelsOptions{PerPage: 100, Page: 1}
+	for {
+		l, resp, err := glc.Labels.ListLabels(pid, o)
+		if err != nil {
+			contextLogger.WithError(err).Error("unable to list the project labels")
+			return nil
+		}
+		for _, x := range l {
+			ls[x.Name] = x
+		}
+		if resp.NextPage == 0 {
+			return ls
+		}
+		o.Page = resp.NextPage
+	}
+}
@@ -0,0 +1,453 @@
+package migrate
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	utils "github.com/wianvos/pigmy/cmd/utils"
+)
+
+// exportTimeFormats are the date layouts jira writes in xml and csv exports
+var exportTimeFormats = []string{
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"02/Jan/06 3:04 PM",
+	"02/Jan/06 15:04",
+	"2006-01-02 15:04",
+	time.RFC3339,
+}
+
+// exportSource extracts projects from a jira xml or csv export and a directory of attachments.
+// the export is read in full up front, it carries everything but the attachment files themselves
+type exportSource struct {
+	attachments string
+	domain      string
+	issues      map[string]*exportIssue
+	order       []string
+	users       map[string]User
+}
+
+// exportIssue is an issue as the export has it, with what the selection needs on top of our model
+type exportIssue struct {
+	Issue
+	Project     string
+	Type        string
+	Attachments []exportAttachment
+}
+
+type exportAttachment struct {
+	ID      string
+	Name    string
+	Author  string
+	Created time.Time
+}
+
+// loadExport reads export file f, a jira xml export when it ends in .xml and a csv export otherwise.
+// users the export knows no mail address of get one in mail domain d
+func loadExport(f, attachments, d string) (*exportSource, error) {
+	fh, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	s := &exportSource{
+		attachments: attachments,
+		domain:      d,
+		issues:      make(map[string]*exportIssue),
+		users:       make(map[string]User),
+	}
+	if strings.EqualFold(filepath.Ext(f), ".xml") {
+		err = s.readXML(fh)
+	} else {
+		err = s.readCSV(fh)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read export %s: %s", f, err)
+	}
+
+	contextLogger.Infof("read %d issues from export %s", len(s.order), f)
+	return s, nil
+}
+
+func (s *exportSource) add(i *exportIssue) {
+	if i.CreatorID == "admin" {
+		i.CreatorID = "root"
+	}
+	if _, ok := s.issues[i.JiraID]; !ok {
+		s.order = append(s.order, i.JiraID)
+	}
+	s.issues[i.JiraID] = i
+}
+
+// addUser remembers the display name of jira user n
+func (s *exportSource) addUser(n, dn string) {
+	if n == "" || dn == "" {
+		return
+	}
+	s.users[n] = User{Username: n, Name: dn}
+}
+
+// xmlUser is a user element of the xml export, cloud exports name users by account id
+type xmlUser struct {
+	Username  string `xml:"username,attr"`
+	AccountID string `xml:"accountid,attr"`
+	Name      string `xml:",chardata"`
+}
+
+func (u xmlUser) name() string {
+	n := u.Username
+	if n == "" {
+		n = u.AccountID
+	}
+	// unassigned issues name user -1
+	if n == "-1" {
+		return ""
+	}
+	return n
+}
+
+// xmlExport is the rss document jira writes for an xml export
+type xmlExport struct {
+	Items []struct {
+		Project struct {
+			Key string `xml:"key,attr"`
+		} `xml:"project"`
+		Key struct {
+			ID  string `xml:"id,attr"`
+			Key string `xml:",chardata"`
+		} `xml:"key"`
+		Summary     string   `xml:"summary"`
+		Description string   `xml:"description"`
+		Type        string   `xml:"type"`
+		Status      string   `xml:"status"`
+		Assignee    xmlUser  `xml:"assignee"`
+		Reporter    xmlUser  `xml:"reporter"`
+		Labels      []string `xml:"labels>label"`
+		Created     string   `xml:"created"`
+		Updated     string   `xml:"updated"`
+		FixVersions []string `xml:"fixVersion"`
+		Comments    []struct {
+			ID      string `xml:"id,attr"`
+			Author  string `xml:"author,attr"`
+			Created string `xml:"created,attr"`
+			Body    string `xml:",chardata"`
+		} `xml:"comments>comment"`
+		Attachments []struct {
+			ID      string `xml:"id,attr"`
+			Name    string `xml:"name,attr"`
+			Author  string `xml:"author,attr"`
+			Created string `xml:"created,attr"`
+		} `xml:"attachments>attachment"`
+	} `xml:"channel>item"`
+}
+
+// readXML reads a jira xml export. the xml export renders descriptions and comments as html,
+// they are turned into markdown. it names no creator, the reporter stands in for it
+func (s *exportSource) readXML(r io.Reader) error {
+	d := xml.NewDecoder(r)
+	// exports of old instances are not always well formed
+	d.Strict = false
+
+	x := xmlExport{}
+	if err := d.Decode(&x); err != nil {
+		return err
+	}
+
+	for _, it := range x.Items {
+		i := &exportIssue{
+			Issue: Issue{
+				JiraID:      it.Key.ID,
+				JiraKey:     strings.TrimSpace(it.Key.Key),
+				Title:       fmt.Sprintf("%s:%s", strings.TrimSpace(it.Key.Key), it.Summary),
+				Description: htmlToMarkdown(it.Description),
+				Status:      it.Status,
+				Assignee:    it.Assignee.name(),
+				CreatorID:   it.Reporter.name(),
+				Labels:      []string{"To Do"},
+				JiraLabels:  it.Labels,
+				FixVersions: it.FixVersions,
+				CreatedAt:   exportTime(it.Created),
+				UpdatedAt:   exportTime(it.Updated),
+				Comments:    Comments{},
+			},
+			Project: it.Project.Key,
+			Type:    it.Type,
+		}
+		s.addUser(it.Assignee.name(), it.Assignee.Name)
+		s.addUser(it.Reporter.name(), it.Reporter.Name)
+
+		for _, c := range it.Comments {
+			i.Comments = append(i.Comments, Comment{
+				JiraID:    c.ID,
+				Body:      htmlToMarkdown(c.Body),
+				CreatorID: c.Author,
+				CreatedAt: exportTime(c.Created),
+			})
+		}
+		for _, a := range it.Attachments {
+			i.Attachments = append(i.Attachments, exportAttachment{
+				ID:      a.ID,
+				Name:    a.Name,
+				Author:  a.Author,
+				Created: exportTime(a.Created),
+			})
+		}
+		s.add(i)
+	}
+	return nil
+}
+
+// readCSV reads a jira csv export. multi valued fields (labels, comments, ...) come as repeated columns
+func (s *exportSource) readCSV(r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	h, err := cr.Read()
+	if err != nil {
+		return err
+	}
+	cols := make(map[string][]int)
+	for x, n := range h {
+		cols[strings.TrimSpace(n)] = append(cols[strings.TrimSpace(n)], x)
+	}
+	if len(cols["Issue id"]) == 0 || len(cols["Issue key"]) == 0 {
+		return fmt.Errorf("no issue id and key columns, this is not a jira csv export")
+	}
+
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// all returns the non empty values of column n, get the first one
+		all := func(n string) []string {
+			var vs []string
+			for _, x := range cols[n] {
+				if x < len(rec) && strings.TrimSpace(rec[x]) != "" {
+					vs = append(vs, rec[x])
+				}
+			}
+			return vs
+		}
+		get := func(n string) string {
+			if vs := all(n); len(vs) != 0 {
+				return vs[0]
+			}
+			return ""
+		}
+
+		cn := get("Creator")
+		if cn == "" {
+			cn = get("Reporter")
+		}
+		i := &exportIssue{
+			Issue: Issue{
+				JiraID:      get("Issue id"),
+				JiraKey:     get("Issue key"),
+				Title:       fmt.Sprintf("%s:%s", get("Issue key"), get("Summary")),
+				Description: get("Description"),
+				Status:      get("Status"),
+				Assignee:    get("Assignee"),
+				CreatorID:   cn,
+				Labels:      []string{"To Do"},
+				JiraLabels:  all("Labels"),
+				FixVersions: all("Fix Version/s"),
+				CreatedAt:   exportTime(get("Created")),
+				UpdatedAt:   exportTime(get("Updated")),
+				Comments:    Comments{},
+			},
+			Project: get("Project key"),
+			Type:    get("Issue Type"),
+		}
+
+		// comments are "created;author;body", the export has no ids so their position stands in
+		for x, c := range all("Comment") {
+			f := strings.SplitN(c, ";", 3)
+			if len(f) != 3 {
+				contextLogger.Errorf("unable to read comment %d of %s", x+1, i.JiraKey)
+				continue
+			}
+			i.Comments = append(i.Comments, Comment{
+				JiraID:    fmt.Sprintf("%s-%d", i.JiraID, x+1),
+				Body:      f[2],
+				CreatorID: f[1],
+				CreatedAt: exportTime(f[0]),
+			})
+		}
+
+		// attachments are "created;author;name;url", the id is in the url
+		for x, a := range all("Attachment") {
+			f := strings.SplitN(a, ";", 3)
+			u := strings.LastIndex(a, ";")
+			if len(f) != 3 || u <= len(f[0])+len(f[1])+1 {
+				contextLogger.Errorf("unable to read attachment %d of %s", x+1, i.JiraKey)
+				continue
+			}
+			i.Attachments = append(i.Attachments, exportAttachment{
+				ID:      path.Base(path.Dir(a[u+1:])),
+				Name:    strings.TrimSuffix(f[2], a[u:]),
+				Author:  f[1],
+				Created: exportTime(f[0]),
+			})
+		}
+
+		s.add(i)
+	}
+}
+
+// exportTime parses a date of the export, the zero time when it can't
+func exportTime(v string) time.Time {
+	v = strings.TrimSpace(v)
+	for _, l := range exportTimeFormats {
+		if t, err := time.Parse(l, v); err == nil {
+			return t
+		}
+	}
+	if v != "" {
+		contextLogger.Errorf("unable to read export date %s", v)
+	}
+	return time.Time{}
+}
+
+// IssueIDs selects the issues of project c from the export. jql needs a jira to run it,
+// the other selection flags are applied here
+func (s *exportSource) IssueIDs(c ProjectConfig, since time.Time) ([]string, error) {
+	if c.JQL != "" {
+		contextLogger.Warnf("jql can't be applied to an export, ignoring: %s", c.JQL)
+	}
+	if updatedSince != "" {
+		t, err := time.Parse("2006-01-02", updatedSince)
+		if err != nil {
+			return nil, fmt.Errorf("an export can only be selected on an updated-since date (yyyy-mm-dd), not %s", updatedSince)
+		}
+		if t.After(since) {
+			since = t
+		}
+	}
+
+	var ids []string
+	for _, id := range s.order {
+		i := s.issues[id]
+		switch {
+		case !strings.EqualFold(i.Project, c.Name):
+		case !since.IsZero() && i.UpdatedAt.Before(since):
+		case !matches(issueTypes, i.Type), !matches(statuses, i.Status), !matches(issueKeys, i.JiraKey):
+		default:
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// matches tells whether v is in selection vs, an empty selection matches anything
+func matches(vs []string, v string) bool {
+	if len(vs) == 0 {
+		return true
+	}
+	for _, x := range vs {
+		if strings.EqualFold(strings.TrimSpace(x), v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Issue returns issue id with its attachments copied out of the attachments directory
+func (s *exportSource) Issue(id string) (Issue, error) {
+	ei, ok := s.issues[id]
+	if !ok {
+		return Issue{}, fmt.Errorf("issue %s is not in the export", id)
+	}
+
+	i := ei.Issue
+	i.Attachements = Attachements{}
+	for _, a := range ei.Attachments {
+		contextLogger := contextLogger.WithFields(log.Fields{"Jira Issue": id, "JiraAttachement": a.ID})
+		tf, err := s.copyAttachment(ei, a)
+		if err != nil {
+			contextLogger.WithError(err).Error("unable to retrieve attachment from the export")
+			continue
+		}
+		i.Attachements = append(i.Attachements, Attachement{
+			JiraID:    a.ID,
+			FileName:  tf,
+			CreatorID: a.Author,
+			CreatedAt: a.Created,
+		})
+	}
+	return i, nil
+}
+
+// attachmentFile finds the file of attachment a. jira homes keep attachments as
+// <project>/<bucket>/<issue key>/<id> (older ones as <project>/<issue key>/<id>), hand made
+// directories tend to have <issue key>/<name>
+func (s *exportSource) attachmentFile(i *exportIssue, a exportAttachment) (string, error) {
+	if s.attachments == "" {
+		return "", fmt.Errorf("no attachments directory given")
+	}
+	ps := []string{
+		filepath.Join(s.attachments, i.Project, "*", i.JiraKey, a.ID),
+		filepath.Join(s.attachments, i.Project, i.JiraKey, a.ID),
+		filepath.Join(s.attachments, i.JiraKey, a.ID),
+		filepath.Join(s.attachments, i.JiraKey, a.Name),
+	}
+	for _, p := range ps {
+		ms, _ := filepath.Glob(p)
+		for _, m := range ms {
+			if fi, err := os.Stat(m); err == nil && fi.Mode().IsRegular() {
+				return m, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("attachment %s (%s) not found in %s", a.ID, a.Name, s.attachments)
+}
+
+// copyAttachment copies attachment a to the tmp dir under its own name, the way downloads end up
+func (s *exportSource) copyAttachment(i *exportIssue, a exportAttachment) (string, error) {
+	f, err := s.attachmentFile(i, a)
+	if err != nil {
+		return "", err
+	}
+	in, err := os.Open(f)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	tf := utils.GetTmpDirFileName(a.Name)
+	out, err := os.Create(tf)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return "", err
+	}
+	return tf, out.Close()
+}
+
+// User returns jira user n as far as the export knows it, with an address in the mail domain
+func (s *exportSource) User(n string) (User, error) {
+	if n == "" {
+		return User{}, fmt.Errorf("no user name")
+	}
+	u, ok := s.users[n]
+	if !ok {
+		u = User{Username: n, Name: n}
+	}
+	u.Email = fmt.Sprintf("%s@%s", n, s.domain)
+	return u, nil
+}
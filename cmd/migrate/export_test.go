@@ -0,0 +1,215 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testXMLExport = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="0.92">
+<channel>
+	<title>Jira</title>
+	<item>
+		<title>[PIG-1] first issue</title>
+		<project id="10000" key="PIG">Pigmy</project>
+		<key id="10001">PIG-1</key>
+		<summary>first issue</summary>
+		<description>&lt;p&gt;some &lt;b&gt;bold&lt;/b&gt; text, see &lt;a href=&quot;https://jira.example.com/browse/PIG-2&quot; class=&quot;issue-link&quot;&gt;PIG-2&lt;/a&gt;&lt;/p&gt;</description>
+		<type id="1">Bug</type>
+		<status id="1">Open</status>
+		<assignee username="jdoe">John Doe</assignee>
+		<reporter username="admin">Administrator</reporter>
+		<labels>
+			<label>backend</label>
+			<label>urgent</label>
+		</labels>
+		<created>Tue, 3 Apr 2018 10:15:00 +0200</created>
+		<updated>Wed, 4 Apr 2018 11:00:00 +0200</updated>
+		<fixVersion>1.0</fixVersion>
+		<comments>
+			<comment id="20001" author="jdoe" created="Tue, 3 Apr 2018 12:00:00 +0200">&lt;p&gt;a comment&lt;/p&gt;</comment>
+		</comments>
+		<attachments>
+			<attachment id="30001" name="screen shot.png" size="1024" author="jdoe" created="Tue, 3 Apr 2018 12:05:00 +0200"/>
+		</attachments>
+	</item>
+	<item>
+		<project id="10000" key="PIG">Pigmy</project>
+		<key id="10002">PIG-2</key>
+		<summary>second issue</summary>
+		<type id="3">Task</type>
+		<status id="6">Closed</status>
+		<assignee username="-1">Unassigned</assignee>
+		<reporter accountid="5b10a2844c20165700ede21g">Jane Roe</reporter>
+		<created>Tue, 3 Apr 2018 10:20:00 +0200</created>
+		<updated>Tue, 3 Apr 2018 10:20:00 +0200</updated>
+	</item>
+</channel>
+</rss>`
+
+func TestReadXML(t *testing.T) {
+	s := &exportSource{issues: make(map[string]*exportIssue), users: make(map[string]User)}
+	if err := s.readXML(strings.NewReader(testXMLExport)); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(s.order, ",") != "10001,10002" {
+		t.Fatalf("issues read in order %v", s.order)
+	}
+
+	i := s.issues["10001"]
+	if i.JiraKey != "PIG-1" || i.Title != "PIG-1:first issue" || i.Project != "PIG" || i.Type != "Bug" || i.Status != "Open" {
+		t.Errorf("unexpected issue %+v", i)
+	}
+	if i.Description != "some **bold** text, see PIG-2" {
+		t.Errorf("description is %q", i.Description)
+	}
+	if i.CreatorID != "root" || i.Assignee != "jdoe" {
+		t.Errorf("creator %s and assignee %s", i.CreatorID, i.Assignee)
+	}
+	if strings.Join(i.JiraLabels, ",") != "backend,urgent" || strings.Join(i.FixVersions, ",") != "1.0" {
+		t.Errorf("labels %v and fix versions %v", i.JiraLabels, i.FixVersions)
+	}
+	if !i.CreatedAt.Equal(time.Date(2018, 4, 3, 8, 15, 0, 0, time.UTC)) {
+		t.Errorf("created at %s", i.CreatedAt)
+	}
+
+	if len(i.Comments) != 1 {
+		t.Fatalf("%d comments read", len(i.Comments))
+	}
+	if c := i.Comments[0]; c.JiraID != "20001" || c.CreatorID != "jdoe" || c.Body != "a comment" || c.CreatedAt.IsZero() {
+		t.Errorf("unexpected comment %+v", c)
+	}
+
+	if len(i.Attachments) != 1 {
+		t.Fatalf("%d attachments read", len(i.Attachments))
+	}
+	if a := i.Attachments[0]; a.ID != "30001" || a.Name != "screen shot.png" || a.Author != "jdoe" || a.Created.IsZero() {
+		t.Errorf("unexpected attachment %+v", a)
+	}
+
+	i = s.issues["10002"]
+	if i.Assignee != "" || i.CreatorID != "5b10a2844c20165700ede21g" || i.Description != "" {
+		t.Errorf("unexpected issue %+v", i)
+	}
+
+	if u := s.users["jdoe"]; u.Name != "John Doe" {
+		t.Errorf("user jdoe is %+v", u)
+	}
+	if _, ok := s.users[""]; ok {
+		t.Error("the unassigned user was added")
+	}
+}
+
+const testCSVExport = `Summary,Issue key,Issue id,Issue Type,Status,Project key,Assignee,Reporter,Creator,Created,Updated,Labels,Labels,Fix Version/s,Description,Comment,Comment,Attachment
+first issue,PIG-1,10001,Bug,Open,PIG,jdoe,jdoe,admin,03/Apr/18 10:15 AM,04/Apr/18 11:00 AM,backend,urgent,1.0,"some {code}code{code}","03/Apr/18 12:00 PM;jdoe;a comment; with a semicolon","03/Apr/18 1:00 PM;jroe;another",03/Apr/18 12:05 PM;jdoe;report;v2.pdf;https://jira.example.com/secure/attachment/30001/report%3Bv2.pdf
+second issue,PIG-2,10002,Task,Closed,PIG,,jroe,,03/Apr/18 10:20 AM,03/Apr/18 10:20 AM,,,,,,not a comment,
+`
+
+func TestReadCSV(t *testing.T) {
+	s := &exportSource{issues: make(map[string]*exportIssue), users: make(map[string]User)}
+	if err := s.readCSV(strings.NewReader(testCSVExport)); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(s.order, ",") != "10001,10002" {
+		t.Fatalf("issues read in order %v", s.order)
+	}
+
+	i := s.issues["10001"]
+	if i.JiraKey != "PIG-1" || i.Title != "PIG-1:first issue" || i.Project != "PIG" || i.Type != "Bug" || i.Status != "Open" {
+		t.Errorf("unexpected issue %+v", i)
+	}
+	if i.Description != "some {code}code{code}" {
+		t.Errorf("description is %q", i.Description)
+	}
+	if i.CreatorID != "root" || i.Assignee != "jdoe" {
+		t.Errorf("creator %s and assignee %s", i.CreatorID, i.Assignee)
+	}
+	if strings.Join(i.JiraLabels, ",") != "backend,urgent" || strings.Join(i.FixVersions, ",") != "1.0" {
+		t.Errorf("labels %v and fix versions %v", i.JiraLabels, i.FixVersions)
+	}
+	if !i.UpdatedAt.Equal(time.Date(2018, 4, 4, 11, 0, 0, 0, time.UTC)) {
+		t.Errorf("updated at %s", i.UpdatedAt)
+	}
+
+	if len(i.Comments) != 2 {
+		t.Fatalf("%d comments read", len(i.Comments))
+	}
+	if c := i.Comments[0]; c.JiraID != "10001-1" || c.CreatorID != "jdoe" || c.Body != "a comment; with a semicolon" || c.CreatedAt.IsZero() {
+		t.Errorf("unexpected comment %+v", c)
+	}
+	if c := i.Comments[1]; c.JiraID != "10001-2" || c.CreatorID != "jroe" || c.Body != "another" {
+		t.Errorf("unexpected comment %+v", c)
+	}
+
+	if len(i.Attachments) != 1 {
+		t.Fatalf("%d attachments read", len(i.Attachments))
+	}
+	if a := i.Attachments[0]; a.ID != "30001" || a.Name != "report;v2.pdf" || a.Author != "jdoe" || a.Created.IsZero() {
+		t.Errorf("unexpected attachment %+v", a)
+	}
+
+	// the creator is missing, the reporter stands in. a malformed comment is left out
+	i = s.issues["10002"]
+	if i.CreatorID != "jroe" || i.Assignee != "" || len(i.Comments) != 0 || len(i.Attachments) != 0 {
+		t.Errorf("unexpected issue %+v", i)
+	}
+}
+
+func TestReadCSVNoExport(t *testing.T) {
+	s := &exportSource{issues: make(map[string]*exportIssue), users: make(map[string]User)}
+	if err := s.readCSV(strings.NewReader("a,b\n1,2\n")); err == nil {
+		t.Error("a csv without issue columns was read")
+	}
+}
+
+func TestExportTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"Tue, 3 Apr 2018 10:15:00 +0200", time.Date(2018, 4, 3, 8, 15, 0, 0, time.UTC)},
+		{"03/Apr/18 1:15 PM", time.Date(2018, 4, 3, 13, 15, 0, 0, time.UTC)},
+		{"03/Apr/18 13:15", time.Date(2018, 4, 3, 13, 15, 0, 0, time.UTC)},
+		{" 2018-04-03 13:15 ", time.Date(2018, 4, 3, 13, 15, 0, 0, time.UTC)},
+		{"2018-04-03T13:15:00Z", time.Date(2018, 4, 3, 13, 15, 0, 0, time.UTC)},
+		{"", time.Time{}},
+		{"yesterday", time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := exportTime(tt.in); !got.Equal(tt.want) {
+			t.Errorf("exportTime(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHTMLToMarkdown(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain text", "plain text"},
+		{"<p>one</p>\n<p>two<br/>\nthree</p>", "one\n\ntwo\nthree"},
+		{"<b>bold</b>, <em>italic</em> and <del>gone</del>", "**bold**, _italic_ and ~~gone~~"},
+		{"<tt>x := 1</tt>", "`x := 1`"},
+		{`<a href="https://example.com">a site</a>`, "[a site](https://example.com)"},
+		{`<a href="https://example.com">https://example.com</a>`, "https://example.com"},
+		{`<a href="https://jira.example.com/browse/PIG-1" class="issue-link">PIG-1</a>`, "PIG-1"},
+		{`<img src="https://example.com/a.png" alt="a.png"/>`, "![a.png](https://example.com/a.png)"},
+		{"<ul><li>one</li><li>two<ul><li>nested</li></ul></li></ul>", "- one\n- two\n  - nested"},
+		{"<ol><li>one</li><li>two</li></ol>", "1. one\n2. two"},
+		{"<h2>title</h2><p>text</p>", "## title\n\ntext"},
+		{"<blockquote><p>quoted</p></blockquote>", "> quoted"},
+		{`<div class="code panel"><div class="codeContent panelContent"><pre class="code-java">int x = 1;
+x++;</pre></div></div>`, "```java\nint x = 1;\nx++;\n```"},
+		{"<table><tr><th>a</th><th>b</th></tr><tr><td>1</td><td>2|3</td></tr></table>", "| a | b |\n| --- | --- |\n| 1 | 2\\|3 |"},
+		{"&lt;tag&gt; &amp; more", "<tag> & more"},
+	}
+	for _, tt := range tests {
+		if got := htmlToMarkdown(tt.in); got != tt.want {
+			t.Errorf("htmlToMarkdown(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
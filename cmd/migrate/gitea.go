@@ -0,0 +1,655 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/wianvos/pigmy/cmd/store"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// giteaPageSize is the number of items asked for per page, gitea caps it at 50 by default
+const giteaPageSize = 50
+
+// addGiteaFlags registers the flags of the gitea target. gitea differs from gitlab in a few places,
+// the flags decide what to do about it
+func addGiteaFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("gitea-url", "", "gitea (or forgejo) server url")
+	cmd.PersistentFlags().String("gitea-token", "", "gitea access token, an admin token to create users and post as them")
+	cmd.PersistentFlags().Bool("gitea-sudo", true, "post issues and comments as their jira authors (needs an admin token)")
+	cmd.PersistentFlags().Bool("gitea-create-users", true, "create the gitea users missing for jira users (needs an admin token)")
+	cmd.PersistentFlags().Bool("gitea-header", true, "name the jira author and date above every issue and comment, gitea can't backdate them")
+	cmd.PersistentFlags().Bool("gitea-milestones", true, "turn jira fix versions into milestones")
+	cmd.PersistentFlags().String("gitea-label-color", "#428bca", "color of the labels created in gitea, which needs one")
+	for f, k := range map[string]string{
+		"gitea-url":          "giteaURL",
+		"gitea-token":        "giteaToken",
+		"gitea-sudo":         "giteaSudo",
+		"gitea-create-users": "giteaCreateUsers",
+		"gitea-header":       "giteaHeader",
+		"gitea-milestones":   "giteaMilestones",
+		"gitea-label-color":  "giteaLabelColor",
+	} {
+		viper.BindPFlag(k, cmd.PersistentFlags().Lookup(f))
+	}
+}
+
+// giteaClient talks to the gitea api, forgejo speaks the same
+type giteaClient struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+func newGiteaClient() (*giteaClient, error) {
+	u := strings.TrimRight(viper.GetString("giteaURL"), "/")
+	if u == "" {
+		return nil, fmt.Errorf("no gitea url configured")
+	}
+	return &giteaClient{base: u + "/api/v1/", token: viper.GetString("giteaToken"), http: http.DefaultClient}, nil
+}
+
+// do sends a request to api path p as user sudo (when set), encoding in as json and decoding the response in out.
+// it returns the response status, responses outside 2xx are an error
+func (c *giteaClient) do(method, p, sudo string, in, out interface{}) (int, error) {
+	var body io.Reader
+	ct := ""
+	if in != nil {
+		j, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body, ct = bytes.NewReader(j), "application/json"
+	}
+	return c.send(method, p, sudo, body, ct, out)
+}
+
+// upload posts file f as form field n to api path p as user sudo
+func (c *giteaClient) upload(p, sudo, n, f string, out interface{}) (int, error) {
+	fh, err := os.Open(f)
+	if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	fw, err := mw.CreateFormFile(n, filepath.Base(f))
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(fw, fh); err != nil {
+		return 0, err
+	}
+	if err := mw.Close(); err != nil {
+		return 0, err
+	}
+	return c.send("POST", p, sudo, &b, mw.FormDataContentType(), out)
+}
+
+func (c *giteaClient) send(method, p, sudo string, body io.Reader, ct string, out interface{}) (int, error) {
+	req, err := http.NewRequest(method, c.base+strings.TrimLeft(p, "/"), body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "token "+c.token)
+	req.Header.Set("Accept", "application/json")
+	if ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	if sudo != "" {
+		req.Header.Set("Sudo", sudo)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		m := struct {
+			Message string `json:"message"`
+		}{}
+		b, _ := ioutil.ReadAll(resp.Body)
+		if json.Unmarshal(b, &m) != nil || m.Message == "" {
+			m.Message = strings.TrimSpace(string(b))
+		}
+		return resp.StatusCode, fmt.Errorf("gitea %s %s: %s %s", method, p, resp.Status, m.Message)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil && err != io.EOF {
+			return resp.StatusCode, err
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// named is what gitea returns for labels and milestones, they are known by name
+type named struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Title string `json:"title"`
+}
+
+// list reads every page of api path p into a name to id map
+func (c *giteaClient) list(p string) (map[string]int64, error) {
+	m := make(map[string]int64)
+	sep := "?"
+	if strings.Contains(p, "?") {
+		sep = "&"
+	}
+	for page := 1; ; page++ {
+		var ns []named
+		if _, err := c.do("GET", fmt.Sprintf("%s%spage=%d&limit=%d", p, sep, page, giteaPageSize), "", nil, &ns); err != nil {
+			return nil, err
+		}
+		for _, n := range ns {
+			if n.Name != "" {
+				m[n.Name] = n.ID
+			} else {
+				m[n.Title] = n.ID
+			}
+		}
+		if len(ns) < giteaPageSize {
+			return m, nil
+		}
+	}
+}
+
+// giteaTarget migrates projects to a gitea repository. the migration state is kept the way it is
+// for gitlab, in a store of its own, with the repository in place of the gitlab project and issue
+// numbers in place of iids
+type giteaTarget struct {
+	// c is the client of the configured server unless one is given
+	c *giteaClient
+	// repo is the owner/name of the repository
+	repo       string
+	users      map[string]bool
+	labels     map[string]int64
+	milestones map[string]int64
+}
+
+// giteaRepo is the part of a gitea repository we care about
+type giteaRepo struct {
+	ID       int64  `json:"id"`
+	FullName string `json:"full_name"`
+	HTMLURL  string `json:"html_url"`
+}
+
+// giteaIssue is the part of a gitea issue or comment we care about
+type giteaIssue struct {
+	ID      int64  `json:"id"`
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+}
+
+// Migrate creates the repository when needed, then the users, labels, milestones and issues
+func (t *giteaTarget) Migrate(p *Project) error {
+	contextLogger := contextLogger.WithField("project", p.Name)
+	fmt.Fprintln(out, "starting project migration to gitea")
+	// gitea state is kept apart from gitlab's, never mistake the one for the other
+	if p.Mapping.Target != store.TargetGitea {
+		return fmt.Errorf("the migration state of %s belongs to a %s target", p.Name, p.Mapping.Target)
+	}
+	if err := loadAuthorship(); err != nil {
+		return err
+	}
+
+	if t.c == nil {
+		c, err := newGiteaClient()
+		if err != nil {
+			return err
+		}
+		t.c = c
+	}
+	c := t.c
+
+	r, err := t.ensureRepo(p)
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to determine the gitea repository")
+		return err
+	}
+	t.repo = r.FullName
+	contextLogger.Infof("migrating to gitea repository %s", t.repo)
+
+	// the state might still be that of another repository
+	if p.Mapping.GitlabPath != "" && p.Mapping.GitlabPath != t.repo && len(p.Mapping.Issues) != 0 {
+		return fmt.Errorf("the migration state of %s belongs to %s, not %s", p.Name, p.Mapping.GitlabPath, t.repo)
+	}
+	p.Pid = int(r.ID)
+	p.Mapping.GitlabPID = p.Pid
+	p.Mapping.GitlabPath = t.repo
+
+	if err := t.createUsers(p); err != nil {
+		contextLogger.Error("unable to migrate users.. ")
+		return err
+	}
+	if t.labels, err = c.list(fmt.Sprintf("repos/%s/labels", t.repo)); err != nil {
+		return err
+	}
+	if t.milestones, err = c.list(fmt.Sprintf("repos/%s/milestones?state=all", t.repo)); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "Migrating Issues")
+	bar := newProgress(len(p.Issues))
+	s := 0
+	e := p.unfetched
+	for x := range p.Issues {
+		if p.canceled() != nil {
+			break
+		}
+		bar.Add(1)
+		i := &p.Issues[x]
+		if err := t.migrateIssue(p, i); err != nil {
+			e = e + 1
+			contextLogger.WithError(err).Errorf("unable to migrate jira issue %s", i.JiraKey)
+		} else {
+			s = s + 1
+		}
+		if err := p.Mapping.Save(); err != nil {
+			contextLogger.WithError(err).Error("unable to save migration state")
+		}
+	}
+
+	t.resolveForwardReferences(p)
+
+	if e == 0 && p.canceled() == nil {
+		p.Mapping.LastRun = p.StartedAt
+	}
+	if err := p.Mapping.Save(); err != nil {
+		contextLogger.WithError(err).Error("unable to save migration state")
+	}
+	p.Migrated = s
+	p.Failed = e
+	fmt.Fprintf(out, "project migrated. %d issues migrated succesfully. %d errors encountered", s, e)
+	if e != 0 {
+		return fmt.Errorf("%d issues of %s failed to migrate", e, p.Name)
+	}
+	return nil
+}
+
+// ensureRepo returns the repository to migrate to, creating it when it does not exist
+func (t *giteaTarget) ensureRepo(p *Project) (*giteaRepo, error) {
+	c := p.Config
+
+	fn := c.Project
+	if fn == "" {
+		o := c.Namespace
+		if o == "" {
+			me := struct {
+				Login string `json:"login"`
+			}{}
+			if _, err := t.c.do("GET", "user", "", nil, &me); err != nil {
+				return nil, err
+			}
+			o = me.Login
+		}
+		n := c.Path
+		if n == "" {
+			n = c.target()
+		}
+		fn = o + "/" + n
+	}
+
+	r := &giteaRepo{}
+	st, err := t.c.do("GET", "repos/"+fn, "", nil, r)
+	if err == nil {
+		return r, nil
+	}
+	if st != http.StatusNotFound || c.Project != "" {
+		return nil, err
+	}
+
+	// gitea has no internal repositories, private is the closest
+	v, err := c.visibility()
+	if err != nil {
+		return nil, err
+	}
+	if v == gitlab.InternalVisibility {
+		contextLogger.Warn("gitea has no internal visibility, creating a private repository")
+	}
+	o := fn[:strings.Index(fn, "/")]
+	cr := map[string]interface{}{
+		"name":        fn[strings.Index(fn, "/")+1:],
+		"description": c.Description,
+		"private":     v != gitlab.PublicVisibility,
+	}
+
+	// the namespace is an organization, another user (needs admin) or ourselves
+	cp := "user/repos"
+	if c.Namespace != "" {
+		if st, err := t.c.do("GET", "orgs/"+o, "", nil, nil); err == nil {
+			cp = fmt.Sprintf("orgs/%s/repos", o)
+		} else if st == http.StatusNotFound {
+			cp = fmt.Sprintf("admin/users/%s/repos", o)
+		} else {
+			return nil, err
+		}
+	}
+	tm := time.Now()
+	_, err = t.c.do("POST", cp, "", cr, r)
+	journalGitea(store.ActionCreate, &store.Object{Kind: store.ObjectProject, Name: fn, JiraProject: p.Name}, tm, err)
+	if err != nil {
+		return nil, err
+	}
+	contextLogger.WithField("repository", r.FullName).Info("repository created")
+
+	// features can only be switched after creation
+	er := make(map[string]interface{})
+	for k, b := range map[string]*bool{"has_issues": c.IssuesEnabled, "has_wiki": c.WikiEnabled, "has_pull_requests": c.MergeRequestsEnabled} {
+		if b != nil {
+			er[k] = *b
+		}
+	}
+	if len(er) != 0 {
+		if _, err := t.c.do("PATCH", "repos/"+r.FullName, "", er, nil); err != nil {
+			contextLogger.WithError(err).Error("unable to set the repository features")
+		}
+	}
+	return r, nil
+}
+
+// createUsers makes sure the jira users exist in gitea and gives them access to the repository
+func (t *giteaTarget) createUsers(p *Project) error {
+	fmt.Fprintln(out, "migrating users")
+	t.users = make(map[string]bool)
+
+	for _, u := range p.Users {
+		if u.Username == "" || u.Username == "admin" || u.Username == "root" {
+			continue
+		}
+		contextLogger := contextLogger.WithField("user", u.Username)
+
+		st, err := t.c.do("GET", "users/"+u.Username, "", nil, nil)
+		if err != nil && st != http.StatusNotFound {
+			return err
+		}
+		if st == http.StatusNotFound {
+			if !viper.GetBool("giteaCreateUsers") {
+				contextLogger.Info("user not in gitea, leaving it out")
+				continue
+			}
+			tm := time.Now()
+			_, err := t.c.do("POST", "admin/users", "", map[string]interface{}{
+				"username":             u.Username,
+				"email":                u.Email,
+				"full_name":            u.Name,
+				"password":             tmpPassword,
+				"must_change_password": true,
+				"send_notify":          false,
+			}, nil)
+			journalGitea(store.ActionCreate, &store.Object{Kind: store.ObjectUser, Name: u.Username}, tm, err)
+			if err != nil {
+				contextLogger.WithError(err).Error("unable to create user")
+				return err
+			}
+		}
+		t.users[u.Username] = true
+
+		// collaborators of an organization repository are fine too, org owners simply outrank them
+		l, err := p.accessLevel(u.Username)
+		if err != nil {
+			return err
+		}
+		if _, err := t.c.do("PUT", fmt.Sprintf("repos/%s/collaborators/%s", t.repo, u.Username), "", map[string]string{"permission": giteaPermission(l)}, nil); err != nil {
+			contextLogger.WithError(err).Error("unable to add user to the repository")
+		}
+	}
+	return nil
+}
+
+// giteaPermission maps a gitlab access level onto the three gitea collaborator permissions
+func giteaPermission(l gitlab.AccessLevelValue) string {
+	switch {
+	case l >= gitlab.MaintainerPermissions:
+		return "admin"
+	case l >= gitlab.DeveloperPermissions:
+		return "write"
+	}
+	return "read"
+}
+
+// as returns the gitea user to post something by jira user u as, empty to post as ourselves
+func (t *giteaTarget) as(u string) string {
+	if viper.GetBool("giteaSudo") && t.users[u] {
+		return u
+	}
+	return ""
+}
+
+// body renders text by jira user u at tm in issue i for gitea
+func (t *giteaTarget) body(p *Project, text, u string, tm time.Time, i *Issue) string {
+	b, _ := p.translate(text)
+	if viper.GetBool("giteaHeader") || t.as(u) == "" {
+		return withHeader(b, u, tm, i)
+	}
+	return b
+}
+
+// issueOptions returns the fields of issue i gitea issues have
+func (t *giteaTarget) issueOptions(p *Project, i *Issue) (map[string]interface{}, error) {
+	o := map[string]interface{}{
+		"title": i.Title,
+		"body":  t.body(p, i.Description, i.CreatorID, i.CreatedAt, i),
+	}
+	if t.users[i.Assignee] {
+		o["assignees"] = []string{i.Assignee}
+	}
+	if viper.GetBool("giteaMilestones") && len(i.FixVersions) != 0 {
+		// a gitea issue has a single milestone, the first fix version it is
+		id, err := t.milestone(p, i.FixVersions[0])
+		if err != nil {
+			return nil, err
+		}
+		o["milestone"] = id
+	}
+	return o, nil
+}
+
+// labelIDs returns the ids of the labels of issue i, creating the missing ones
+func (t *giteaTarget) labelIDs(p *Project, i *Issue) ([]int64, error) {
+	var ids []int64
+	for _, l := range i.Labels {
+		id, ok := t.labels[l]
+		if !ok {
+			n := named{}
+			tm := time.Now()
+			_, err := t.c.do("POST", fmt.Sprintf("repos/%s/labels", t.repo), "", map[string]string{"name": l, "color": viper.GetString("giteaLabelColor")}, &n)
+			journalGitea(store.ActionCreate, &store.Object{Kind: store.ObjectLabel, ProjectID: p.Pid, Name: l, JiraProject: p.Name}, tm, err)
+			if err != nil {
+				return nil, err
+			}
+			id = n.ID
+			t.labels[l] = id
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// milestone returns the id of milestone n, creating it when it does not exist
+func (t *giteaTarget) milestone(p *Project, n string) (int64, error) {
+	if id, ok := t.milestones[n]; ok {
+		return id, nil
+	}
+	m := named{}
+	tm := time.Now()
+	_, err := t.c.do("POST", fmt.Sprintf("repos/%s/milestones", t.repo), "", map[string]string{"title": n}, &m)
+	journalGitea(store.ActionCreate, &store.Object{Kind: store.ObjectMilestone, ProjectID: p.Pid, Name: n, JiraProject: p.Name}, tm, err)
+	if err != nil {
+		return 0, err
+	}
+	t.milestones[n] = m.ID
+	return m.ID, nil
+}
+
+// migrateIssue creates issue i in gitea, or brings the one migrated before up to date
+func (t *giteaTarget) migrateIssue(p *Project, i *Issue) error {
+	contextLogger := log.WithFields(log.Fields{"JiraIssueID": i.JiraID})
+
+	o, err := t.issueOptions(p, i)
+	if err != nil {
+		return err
+	}
+	lids, err := t.labelIDs(p, i)
+	if err != nil {
+		return err
+	}
+
+	m := p.Mapping.Issue(i.JiraID)
+	tm := time.Now()
+	if m == nil {
+		o["labels"] = lids
+		o["closed"] = i.Status != "Open"
+		gi := giteaIssue{}
+		_, err := t.c.do("POST", fmt.Sprintf("repos/%s/issues", t.repo), t.as(i.CreatorID), o, &gi)
+		journalGitea(store.ActionCreate, t.object(p, i, gi.Number), tm, err)
+		if err != nil {
+			return err
+		}
+		contextLogger.Infof("issue created")
+		m = &store.Issue{JiraID: i.JiraID, JiraKey: i.JiraKey, IID: gi.Number, WebURL: gi.HTMLURL}
+	} else {
+		o["state"] = "open"
+		if i.Status != "Open" {
+			o["state"] = "closed"
+		}
+		_, err := t.c.do("PATCH", fmt.Sprintf("repos/%s/issues/%d", t.repo, m.IID), "", o, nil)
+		if err == nil {
+			_, err = t.c.do("PUT", fmt.Sprintf("repos/%s/issues/%d/labels", t.repo, m.IID), "", map[string][]int64{"labels": lids}, nil)
+		}
+		journalGitea(store.ActionUpdate, t.object(p, i, m.IID), tm, err)
+		if err != nil {
+			return err
+		}
+		contextLogger.Infof("issue updated")
+	}
+	p.Mapping.SetIssue(m)
+
+	for _, c := range i.Comments {
+		if _, ok := m.Comments[c.JiraID]; ok {
+			continue
+		}
+		id, err := t.comment(p, i, m.IID, t.body(p, c.Body, c.CreatorID, c.CreatedAt, i), c.CreatorID, c.JiraID, "")
+		if err != nil {
+			return err
+		}
+		m.Comments[c.JiraID] = id
+	}
+
+	for _, a := range i.Attachements {
+		contextLogger := contextLogger.WithField("Filename", a.FileName)
+		if _, ok := m.Attachments[a.JiraID]; ok {
+			os.Remove(a.FileName)
+			continue
+		}
+		id, err := t.attach(p, i, m.IID, a)
+		if err != nil {
+			// the issue failed, the next run uploads the attachements still missing
+			contextLogger.Error(err)
+			p.Mapping.SetIssue(m)
+			return err
+		}
+		m.Attachments[a.JiraID] = id
+		os.Remove(a.FileName)
+	}
+
+	m.PendingRefs = p.hasPendingReferences(i)
+	p.Mapping.SetIssue(m)
+	return nil
+}
+
+// comment posts body on issue number n as jira user u, ca or ja names the jira comment or attachment
+func (t *giteaTarget) comment(p *Project, i *Issue, n int, body, u, ca, ja string) (int, error) {
+	gc := giteaIssue{}
+	tm := time.Now()
+	_, err := t.c.do("POST", fmt.Sprintf("repos/%s/issues/%d/comments", t.repo, n), t.as(u), map[string]string{"body": body}, &gc)
+	o := t.object(p, i, n)
+	o.Kind, o.ID, o.JiraComment, o.JiraAttachment, o.Author, o.Unmapped = store.ObjectNote, int(gc.ID), ca, ja, u, nil
+	journalGitea(store.ActionCreate, o, tm, err)
+	return int(gc.ID), err
+}
+
+// attach uploads attachement a to issue number n and links it from a comment
+func (t *giteaTarget) attach(p *Project, i *Issue, n int, a Attachement) (int, error) {
+	as := struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	}{}
+	if _, err := t.c.upload(fmt.Sprintf("repos/%s/issues/%d/assets", t.repo, n), t.as(a.CreatorID), "attachment", a.FileName, &as); err != nil {
+		return 0, err
+	}
+	l := fmt.Sprintf("[%s](%s)", as.Name, as.URL)
+	switch strings.ToLower(filepath.Ext(as.Name)) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".svg":
+		l = "!" + l
+	}
+	b := l
+	if viper.GetBool("giteaHeader") || t.as(a.CreatorID) == "" {
+		b = withHeader(l, a.CreatorID, a.CreatedAt, i)
+	}
+	return t.comment(p, i, n, b, a.CreatorID, "", a.JiraID)
+}
+
+// object describes issue i as gitea issue number n for the run journal
+func (t *giteaTarget) object(p *Project, i *Issue, n int) *store.Object {
+	// not i.object, which looks the users up in gitlab
+	o := &store.Object{
+		Kind:        store.ObjectIssue,
+		ProjectID:   p.Pid,
+		IssueIID:    n,
+		JiraProject: p.Name,
+		JiraID:      i.JiraID,
+		JiraKey:     i.JiraKey,
+		Author:      i.CreatorID,
+	}
+	if !t.users[i.CreatorID] {
+		o.Unmapped = append(o.Unmapped, "author")
+	}
+	if i.Assignee != "" && !t.users[i.Assignee] {
+		o.Unmapped = append(o.Unmapped, "assignee")
+	}
+	if _, ok := p.Config.Statuses[i.Status]; len(p.Config.Statuses) != 0 && !ok {
+		o.Unmapped = append(o.Unmapped, "status")
+	}
+	if len(i.FixVersions) > 1 || len(i.FixVersions) == 1 && !viper.GetBool("giteaMilestones") {
+		o.Unmapped = append(o.Unmapped, "fixVersions")
+	}
+	return o
+}
+
+// resolveForwardReferences rewrites the issue descriptions that referenced issues created later on.
+// comments keep their references, gitea only lets their authors edit them
+func (t *giteaTarget) resolveForwardReferences(p *Project) {
+	for _, i := range p.pendingIssues() {
+		m := p.Mapping.Issue(i.JiraID)
+		b := t.body(p, i.Description, i.CreatorID, i.CreatedAt, i)
+		if _, err := t.c.do("PATCH", fmt.Sprintf("repos/%s/issues/%d", t.repo, m.IID), "", map[string]string{"body": b}, nil); err != nil {
+			contextLogger.WithError(err).Errorf("unable to resolve the references of %s", i.JiraKey)
+			continue
+		}
+		m.PendingRefs = p.hasPendingReferences(i)
+		p.Mapping.SetIssue(m)
+	}
+}
+
+// journalGitea records gitea actions in the run journal. they are journaled as a different
+// kind than their gitlab counterparts, rollback can't undo them
+func journalGitea(a string, o *store.Object, t time.Time, err error) {
+	o.Kind = store.GiteaPrefix + o.Kind
+	journal(a, o, t, err)
+}
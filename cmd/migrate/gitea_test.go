@@ -0,0 +1,589 @@
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/wianvos/pigmy/cmd/store"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// fakeGitea is an in memory stand-in for the parts of the gitea api the target uses
+type fakeGitea struct {
+	mu       sync.Mutex
+	requests []string
+	sudo     map[string]string
+
+	orgs       map[string]bool
+	users      map[string]bool
+	repos      map[string]*giteaRepo
+	labels     []named
+	milestones []named
+	issues     map[int]map[string]interface{}
+	comments   map[int][]string
+	assets     []string
+	lastID     int64
+}
+
+func newFakeGitea() *fakeGitea {
+	return &fakeGitea{
+		sudo:     make(map[string]string),
+		orgs:     make(map[string]bool),
+		users:    make(map[string]bool),
+		repos:    make(map[string]*giteaRepo),
+		issues:   make(map[int]map[string]interface{}),
+		comments: make(map[int][]string),
+	}
+}
+
+// called tells how many requests matched method and path pattern p
+func (f *fakeGitea) called(method, p string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := 0
+	for _, r := range f.requests {
+		if ok, _ := path.Match(method+" "+p, r); ok {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeGitea) id() int64 {
+	f.lastID++
+	return f.lastID
+}
+
+func (f *fakeGitea) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p := strings.TrimPrefix(r.URL.Path, "/api/v1/")
+	f.requests = append(f.requests, r.Method+" "+p)
+	if s := r.Header.Get("Sudo"); s != "" {
+		f.sudo[r.Method+" "+p] = s
+	}
+	if r.Header.Get("Authorization") != "token secret" {
+		reply(w, http.StatusUnauthorized, map[string]string{"message": "token is required"})
+		return
+	}
+
+	in := make(map[string]interface{})
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		json.NewDecoder(r.Body).Decode(&in)
+	}
+	seg := strings.Split(p, "/")
+	match := func(method, pattern string) bool {
+		ok, _ := path.Match(pattern, p)
+		return ok && r.Method == method
+	}
+	notFound := func() {
+		reply(w, http.StatusNotFound, map[string]string{"message": "The target couldn't be found."})
+	}
+	repo := func() *giteaRepo {
+		return f.repos[seg[1]+"/"+seg[2]]
+	}
+	issue := func() map[string]interface{} {
+		if repo() == nil {
+			return nil
+		}
+		n, _ := strconv.Atoi(seg[4])
+		return f.issues[n]
+	}
+
+	switch {
+	case match("GET", "user"):
+		reply(w, http.StatusOK, map[string]string{"login": "me"})
+	case match("GET", "orgs/*"):
+		if !f.orgs[seg[1]] {
+			notFound()
+			return
+		}
+		reply(w, http.StatusOK, map[string]string{"username": seg[1]})
+	case match("GET", "users/*"):
+		if !f.users[seg[1]] {
+			notFound()
+			return
+		}
+		reply(w, http.StatusOK, map[string]string{"login": seg[1]})
+	case match("POST", "admin/users"):
+		f.users[in["username"].(string)] = true
+		reply(w, http.StatusCreated, map[string]string{"login": in["username"].(string)})
+
+	case match("POST", "user/repos"), match("POST", "orgs/*/repos"), match("POST", "admin/users/*/repos"):
+		o := "me"
+		if seg[0] != "user" {
+			o = seg[len(seg)-2]
+		}
+		rp := &giteaRepo{ID: f.id(), FullName: o + "/" + in["name"].(string)}
+		f.repos[rp.FullName] = rp
+		reply(w, http.StatusCreated, rp)
+	case match("GET", "repos/*/*"):
+		if repo() == nil {
+			notFound()
+			return
+		}
+		reply(w, http.StatusOK, repo())
+	case match("PATCH", "repos/*/*"):
+		reply(w, http.StatusOK, repo())
+	case match("PUT", "repos/*/*/collaborators/*"):
+		w.WriteHeader(http.StatusNoContent)
+
+	case match("GET", "repos/*/*/labels"):
+		replyPage(w, r, f.labels)
+	case match("POST", "repos/*/*/labels"):
+		l := named{ID: f.id(), Name: in["name"].(string)}
+		f.labels = append(f.labels, l)
+		reply(w, http.StatusCreated, l)
+	case match("GET", "repos/*/*/milestones"):
+		replyPage(w, r, f.milestones)
+	case match("POST", "repos/*/*/milestones"):
+		m := named{ID: f.id(), Title: in["title"].(string)}
+		f.milestones = append(f.milestones, m)
+		reply(w, http.StatusCreated, m)
+
+	case match("POST", "repos/*/*/issues"):
+		n := len(f.issues) + 1
+		f.issues[n] = in
+		reply(w, http.StatusCreated, giteaIssue{ID: f.id(), Number: n, HTMLURL: fmt.Sprintf("http://gitea/%s/issues/%d", repo().FullName, n)})
+	case match("PATCH", "repos/*/*/issues/*"):
+		i := issue()
+		if i == nil {
+			notFound()
+			return
+		}
+		for k, v := range in {
+			i[k] = v
+		}
+		reply(w, http.StatusCreated, i)
+	case match("PUT", "repos/*/*/issues/*/labels"):
+		i := issue()
+		if i == nil {
+			notFound()
+			return
+		}
+		i["labels"] = in["labels"]
+		reply(w, http.StatusOK, []named{})
+	case match("POST", "repos/*/*/issues/*/comments"):
+		if issue() == nil {
+			notFound()
+			return
+		}
+		n, _ := strconv.Atoi(seg[4])
+		f.comments[n] = append(f.comments[n], in["body"].(string))
+		reply(w, http.StatusCreated, giteaIssue{ID: f.id()})
+	case match("POST", "repos/*/*/issues/*/assets"):
+		fh, h, err := r.FormFile("attachment")
+		if err != nil || issue() == nil {
+			notFound()
+			return
+		}
+		b, _ := ioutil.ReadAll(fh)
+		f.assets = append(f.assets, h.Filename+":"+string(b))
+		reply(w, http.StatusCreated, map[string]string{"name": h.Filename, "browser_download_url": "http://gitea/attachments/" + h.Filename})
+	default:
+		notFound()
+	}
+}
+
+func reply(w http.ResponseWriter, st int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(st)
+	json.NewEncoder(w).Encode(v)
+}
+
+// replyPage answers with the page of ns the page and limit parameters ask for
+func replyPage(w http.ResponseWriter, r *http.Request, ns []named) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	from := (page - 1) * limit
+	if page < 1 || limit < 1 || from > len(ns) {
+		reply(w, http.StatusOK, []named{})
+		return
+	}
+	to := from + limit
+	if to > len(ns) {
+		to = len(ns)
+	}
+	reply(w, http.StatusOK, ns[from:to])
+}
+
+// testGitea starts a fake gitea and returns a target talking to it and a project to migrate with it
+func testGitea(t *testing.T) (*fakeGitea, *giteaTarget, *Project, func()) {
+	d, err := ioutil.TempDir("", "pigmy-gitea")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viper.Set("stateDir", d)
+	viper.Set("jiraURL", "https://jira.example.com")
+	viper.Set("giteaSudo", true)
+	viper.Set("giteaCreateUsers", true)
+	viper.Set("giteaHeader", true)
+	viper.Set("giteaMilestones", true)
+	viper.Set("giteaLabelColor", "#428bca")
+
+	f := newFakeGitea()
+	srv := httptest.NewServer(f)
+	tg := &giteaTarget{c: &giteaClient{base: srv.URL + "/api/v1/", token: "secret", http: srv.Client()}}
+
+	st, err := store.LoadTarget(store.TargetGitea, "PIG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Project{
+		Name:      "PIG",
+		Config:    ProjectConfig{Name: "PIG"},
+		Mapping:   st,
+		StartedAt: time.Now(),
+		access:    make(map[string]gitlab.AccessLevelValue),
+	}
+
+	return f, tg, p, func() {
+		srv.Close()
+		os.RemoveAll(d)
+	}
+}
+
+func TestGiteaEnsureRepo(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ProjectConfig
+		create  string
+		want    string
+		wantErr bool
+	}{
+		{"organization", ProjectConfig{Name: "PIG", Namespace: "team"}, "orgs/team/repos", "team/PIG", false},
+		{"other user", ProjectConfig{Name: "PIG", Namespace: "jdoe"}, "admin/users/jdoe/repos", "jdoe/PIG", false},
+		{"token owner", ProjectConfig{Name: "PIG", Path: "pigmy"}, "user/repos", "me/pigmy", false},
+		{"existing", ProjectConfig{Name: "PIG", Namespace: "team", Target: "existing"}, "", "team/existing", false},
+		{"configured but missing", ProjectConfig{Name: "PIG", Project: "team/missing"}, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, tg, p, done := testGitea(t)
+			defer done()
+			f.orgs["team"] = true
+			f.repos["team/existing"] = &giteaRepo{ID: 7, FullName: "team/existing"}
+			p.Config = tt.config
+
+			r, err := tg.ensureRepo(p)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("repository %s returned", r.FullName)
+				}
+				if !strings.Contains(err.Error(), "404") {
+					t.Errorf("error %s does not tell the repository was not found", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.FullName != tt.want {
+				t.Errorf("repository %s, want %s", r.FullName, tt.want)
+			}
+			// only the existing repository was there to begin with
+			n := 1
+			if tt.create != "" {
+				n = 2
+				if f.called("POST", tt.create) != 1 {
+					t.Errorf("repository not created through %s", tt.create)
+				}
+			}
+			if len(f.repos) != n {
+				t.Errorf("%d repositories, want %d", len(f.repos), n)
+			}
+		})
+	}
+}
+
+func TestGiteaList(t *testing.T) {
+	f, tg, _, done := testGitea(t)
+	defer done()
+	f.repos["team/PIG"] = &giteaRepo{ID: 1, FullName: "team/PIG"}
+	for x := 0; x < giteaPageSize*2+3; x++ {
+		f.labels = append(f.labels, named{ID: int64(x + 1), Name: fmt.Sprintf("label-%d", x)})
+	}
+	f.milestones = []named{{ID: 9, Title: "1.0"}}
+
+	ls, err := tg.c.list("repos/team/PIG/labels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls) != giteaPageSize*2+3 || ls["label-102"] != 103 {
+		t.Errorf("%d labels listed", len(ls))
+	}
+	if n := f.called("GET", "repos/team/PIG/labels"); n != 3 {
+		t.Errorf("labels listed in %d pages", n)
+	}
+
+	ms, err := tg.c.list("repos/team/PIG/milestones?state=all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 1 || ms["1.0"] != 9 {
+		t.Errorf("milestones listed as %v", ms)
+	}
+
+	if _, err := tg.c.list("repos/team/gone/labels/x"); err == nil {
+		t.Error("no error listing what doesn't exist")
+	}
+}
+
+func TestGiteaMigrate(t *testing.T) {
+	f, tg, p, done := testGitea(t)
+	defer done()
+	f.orgs["team"] = true
+	f.users["jdoe"] = true
+	f.labels = []named{{ID: 100, Name: "Bug"}}
+
+	a, err := ioutil.TempFile("", "pigmy-attachment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.WriteString("screenshot")
+	a.Close()
+
+	created := time.Date(2018, 4, 3, 10, 15, 0, 0, time.UTC)
+	p.Config.Namespace = "team"
+	p.Users = Users{{Username: "jdoe", Name: "John Doe", Email: "jdoe@example.com"}, {Username: "jroe", Name: "Jane Roe", Email: "jroe@example.com"}}
+	p.Issues = Issues{{
+		JiraID:      "10001",
+		JiraKey:     "PIG-1",
+		Title:       "PIG-1:first issue",
+		Description: "see PIG-2",
+		Status:      "Open",
+		CreatorID:   "jdoe",
+		Assignee:    "jroe",
+		Labels:      []string{"Bug", "To Do"},
+		FixVersions: []string{"1.0"},
+		CreatedAt:   created,
+		Comments:    Comments{{JiraID: "20001", Body: "a comment", CreatorID: "jroe", CreatedAt: created}},
+		Attachements: Attachements{{
+			JiraID:    "30001",
+			FileName:  a.Name(),
+			CreatorID: "jdoe",
+			CreatedAt: created,
+		}},
+	}}
+
+	if err := tg.Migrate(p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Migrated != 1 || p.Failed != 0 {
+		t.Fatalf("%d issues migrated, %d failed", p.Migrated, p.Failed)
+	}
+
+	// the repository, the missing user, label and milestone are created
+	if f.repos["team/PIG"] == nil {
+		t.Fatal("repository not created")
+	}
+	if !f.users["jroe"] || f.called("POST", "admin/users") != 1 {
+		t.Error("missing user not created, or existing user created again")
+	}
+	if f.called("PUT", "repos/team/PIG/collaborators/*") != 2 {
+		t.Error("users not added to the repository")
+	}
+	if f.called("POST", "repos/team/PIG/labels") != 1 || f.called("POST", "repos/team/PIG/milestones") != 1 {
+		t.Error("expected a single label and a single milestone to be created")
+	}
+
+	// the issue is created as its author, with the labels and milestone
+	i := f.issues[1]
+	if i == nil || len(f.issues) != 1 {
+		t.Fatalf("issues created: %v", f.issues)
+	}
+	if i["title"] != "PIG-1:first issue" || i["closed"] != false {
+		t.Errorf("issue created as %v", i)
+	}
+	if !strings.Contains(i["body"].(string), "see PIG-2") || !strings.Contains(i["body"].(string), "@jdoe") {
+		t.Errorf("issue body %q", i["body"])
+	}
+	if fmt.Sprint(i["labels"]) != fmt.Sprintf("[100 %d]", f.labels[1].ID) || i["milestone"] != float64(f.milestones[0].ID) {
+		t.Errorf("issue labels %v and milestone %v", i["labels"], i["milestone"])
+	}
+	if fmt.Sprint(i["assignees"]) != "[jroe]" {
+		t.Errorf("issue assigned to %v", i["assignees"])
+	}
+	if s := f.sudo["POST repos/team/PIG/issues"]; s != "jdoe" {
+		t.Errorf("issue created as %q", s)
+	}
+
+	// the comment and the attachment each get a comment, the attachment is uploaded first
+	if len(f.comments[1]) != 2 || !strings.Contains(f.comments[1][0], "a comment") {
+		t.Fatalf("comments %q", f.comments[1])
+	}
+	n := filepath.Base(a.Name())
+	if len(f.assets) != 1 || f.assets[0] != n+":screenshot" {
+		t.Errorf("assets uploaded: %q", f.assets)
+	}
+	if !strings.Contains(f.comments[1][1], "(http://gitea/attachments/"+n+")") {
+		t.Errorf("attachment comment %q", f.comments[1][1])
+	}
+	if _, err := os.Stat(a.Name()); !os.IsNotExist(err) {
+		t.Error("attachment file left behind")
+	}
+
+	// everything is in the gitea state, which is kept apart from gitlab's
+	m := p.Mapping.Issue("10001")
+	if m == nil || m.IID != 1 || m.Comments["20001"] == 0 || m.Attachments["30001"] == 0 {
+		t.Fatalf("mapping %+v", m)
+	}
+	if !m.PendingRefs {
+		t.Error("the reference to PIG-2 is not pending")
+	}
+	if p.Mapping.GitlabPath != "team/PIG" || p.Mapping.LastRun.IsZero() {
+		t.Errorf("state %+v", p.Mapping)
+	}
+	if _, err := os.Stat(store.TargetPath(store.TargetGitea, "PIG")); err != nil {
+		t.Errorf("gitea state not saved: %s", err)
+	}
+	if _, err := os.Stat(store.Path("PIG")); !os.IsNotExist(err) {
+		t.Error("gitea state saved as gitlab state")
+	}
+
+	// a second run updates the issue and only adds what is new
+	p.Issues[0].Title = "PIG-1:renamed"
+	p.Issues[0].Status = "Done"
+	p.Issues[0].Attachements = nil
+	p.Issues[0].Comments = append(p.Issues[0].Comments, Comment{JiraID: "20002", Body: "another", CreatorID: "jdoe", CreatedAt: created})
+	patched := f.called("PATCH", "repos/team/PIG/issues/1")
+	tg2 := &giteaTarget{c: tg.c}
+	if err := tg2.Migrate(p); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.issues) != 1 || f.called("PATCH", "repos/team/PIG/issues/1") == patched || f.called("PUT", "repos/team/PIG/issues/1/labels") != 1 {
+		t.Fatal("issue not updated in place")
+	}
+	if i["title"] != "PIG-1:renamed" || i["state"] != "closed" {
+		t.Errorf("issue updated to %v", i)
+	}
+	if len(f.comments[1]) != 3 || !strings.Contains(f.comments[1][2], "another") {
+		t.Errorf("comments %q", f.comments[1])
+	}
+	if len(f.repos) != 1 || f.called("POST", "repos/team/PIG/labels") != 1 {
+		t.Error("repository or labels created again")
+	}
+}
+
+func TestGiteaMigrateIssueGone(t *testing.T) {
+	f, tg, p, done := testGitea(t)
+	defer done()
+	f.repos["me/PIG"] = &giteaRepo{ID: 3, FullName: "me/PIG"}
+
+	// the issue was migrated before, but has been deleted in gitea since
+	p.Mapping.SetIssue(&store.Issue{JiraID: "10001", JiraKey: "PIG-1", IID: 5})
+	p.Issues = Issues{{JiraID: "10001", JiraKey: "PIG-1", Title: "PIG-1:gone", Status: "Open", CreatorID: "jdoe"}}
+
+	if err := tg.Migrate(p); err == nil {
+		t.Error("a failed issue went unreported")
+	}
+	if p.Failed != 1 || p.Migrated != 0 {
+		t.Errorf("%d issues migrated, %d failed", p.Migrated, p.Failed)
+	}
+	if !p.Mapping.LastRun.IsZero() {
+		t.Error("a failed run moved the last run")
+	}
+}
+
+func TestGiteaMigrateAttachmentFails(t *testing.T) {
+	f, tg, p, done := testGitea(t)
+	defer done()
+	f.repos["me/PIG"] = &giteaRepo{ID: 3, FullName: "me/PIG"}
+
+	p.Issues = Issues{{JiraID: "10001", JiraKey: "PIG-1", Title: "PIG-1:first", Status: "Open", CreatorID: "jdoe",
+		Attachements: Attachements{{JiraID: "30001", FileName: filepath.Join(os.TempDir(), "pigmy-missing-attachment"), CreatorID: "jdoe"}},
+	}}
+
+	if err := tg.Migrate(p); err == nil {
+		t.Error("a failed attachment went unreported")
+	}
+	if p.Failed != 1 || p.Migrated != 0 {
+		t.Errorf("%d issues migrated, %d failed", p.Migrated, p.Failed)
+	}
+	if !p.Mapping.LastRun.IsZero() {
+		t.Error("a failed run moved the last run")
+	}
+	// the issue itself is there, the next run only uploads the attachment
+	m := p.Mapping.Issue("10001")
+	if m == nil || m.IID != 1 {
+		t.Fatalf("mapping %+v", m)
+	}
+	if _, ok := m.Attachments["30001"]; ok {
+		t.Error("the failed attachment was recorded")
+	}
+}
+
+func TestGiteaCreateUsers(t *testing.T) {
+	f, tg, p, done := testGitea(t)
+	defer done()
+	f.repos["me/PIG"] = &giteaRepo{ID: 3, FullName: "me/PIG"}
+	tg.repo = "me/PIG"
+	viper.Set("giteaCreateUsers", false)
+	p.Users = Users{{Username: "jroe"}, {Username: "admin"}}
+
+	if err := tg.createUsers(p); err != nil {
+		t.Fatal(err)
+	}
+	if tg.users["jroe"] || f.called("POST", "admin/users") != 0 {
+		t.Error("user created while user creation is off")
+	}
+	if f.called("GET", "users/admin") != 0 {
+		t.Error("the admin user was looked up")
+	}
+	if tg.as("jroe") != "" {
+		t.Error("posting as a user that doesn't exist")
+	}
+}
+
+func TestGiteaTargetState(t *testing.T) {
+	f, tg, p, done := testGitea(t)
+	defer done()
+
+	// the state of one kind of target is refused by the other, before anything is done
+	st, err := store.Load("PIG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.GitlabPID = 42
+	if err := tg.Migrate(&Project{Name: "PIG", Mapping: st}); err == nil {
+		t.Error("gitea migrated with gitlab state")
+	}
+	if len(f.requests) != 0 {
+		t.Errorf("gitea asked for %v", f.requests)
+	}
+	if err := (gitlabTarget{}).Migrate(&Project{Name: "PIG", Mapping: p.Mapping}); err == nil {
+		t.Error("gitlab migrated with gitea state")
+	}
+}
+
+func TestGiteaSendError(t *testing.T) {
+	_, tg, _, done := testGitea(t)
+	defer done()
+
+	st, err := tg.c.do("GET", "repos/team/missing", "", nil, nil)
+	if st != http.StatusNotFound || err == nil || !strings.Contains(err.Error(), "The target couldn't be found.") {
+		t.Errorf("status %d, error %v", st, err)
+	}
+
+	tg.c.token = "wrong"
+	st, err = tg.c.do("GET", "user", "", nil, nil)
+	if st != http.StatusUnauthorized || err == nil || !strings.Contains(err.Error(), "token is required") {
+		t.Errorf("status %d, error %v", st, err)
+	}
+}
@@ -0,0 +1,437 @@
+package migrate
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	utils "github.com/wianvos/pigmy/cmd/utils"
+)
+
+// githubSource extracts the issues of a repository from a github migration archive, the json export
+// github (enterprise) hands out. issues are keyed by their url and named owner/repo#number.
+// milestones end up as the fix version of their issues, the way a jira fix version would
+type githubSource struct {
+	dir         string
+	repos       []githubRepo
+	issues      map[string]*exportIssue
+	order       []string
+	users       map[string]User
+	milestones  map[string]string
+	attachments map[string][]githubAttachment
+	keys        map[string]bool
+}
+
+type githubRepo struct {
+	URL  string `json:"url"`
+	Name string `json:"name"`
+}
+
+type githubUser struct {
+	Login  string `json:"login"`
+	Name   string `json:"name"`
+	Emails []struct {
+		Address string `json:"address"`
+		Primary bool   `json:"primary"`
+	} `json:"emails"`
+}
+
+type githubIssue struct {
+	URL        string     `json:"url"`
+	Repository string     `json:"repository"`
+	User       string     `json:"user"`
+	Title      string     `json:"title"`
+	Body       string     `json:"body"`
+	Assignee   string     `json:"assignee"`
+	Assignees  []string   `json:"assignees"`
+	Milestone  string     `json:"milestone"`
+	Labels     []string   `json:"labels"`
+	ClosedAt   *time.Time `json:"closed_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+type githubComment struct {
+	URL       string    `json:"url"`
+	Issue     string    `json:"issue"`
+	User      string    `json:"user"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type githubMilestone struct {
+	URL   string `json:"url"`
+	Title string `json:"title"`
+}
+
+type githubAttachment struct {
+	Issue     string    `json:"issue"`
+	User      string    `json:"user"`
+	AssetName string    `json:"asset_name"`
+	AssetURL  string    `json:"asset_url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// loadGithubArchive reads github migration archive f, an extracted directory or the .tar.gz itself
+func loadGithubArchive(f string) (*githubSource, error) {
+	fi, err := os.Stat(f)
+	if err != nil {
+		return nil, err
+	}
+	d := f
+	if !fi.IsDir() {
+		d = filepath.Join(utils.GetTmpDir(), strings.TrimSuffix(filepath.Base(f), ".tar.gz"))
+		if err := untar(f, d); err != nil {
+			return nil, fmt.Errorf("unable to extract github archive %s: %s", f, err)
+		}
+	}
+
+	s := &githubSource{
+		dir:         d,
+		issues:      make(map[string]*exportIssue),
+		users:       make(map[string]User),
+		milestones:  make(map[string]string),
+		attachments: make(map[string][]githubAttachment),
+		keys:        make(map[string]bool),
+	}
+	if err := s.read(); err != nil {
+		return nil, fmt.Errorf("unable to read github archive %s: %s", f, err)
+	}
+	contextLogger.Infof("read %d issues from github archive %s", len(s.order), f)
+	return s, nil
+}
+
+// readAll decodes every <kind>_NNNNNN.json file of the archive into v, a pointer to a slice
+func (s *githubSource) readAll(kind string, v interface{}) error {
+	fs, err := filepath.Glob(filepath.Join(s.dir, kind+"_*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(fs)
+
+	// the files are split in chunks, collect them as raw messages and decode them in one go
+	var all []json.RawMessage
+	for _, f := range fs {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		var chunk []json.RawMessage
+		if err := json.Unmarshal(b, &chunk); err != nil {
+			return fmt.Errorf("%s: %s", f, err)
+		}
+		all = append(all, chunk...)
+	}
+	b, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (s *githubSource) read() error {
+	if err := s.readAll("repositories", &s.repos); err != nil {
+		return err
+	}
+
+	var us []githubUser
+	if err := s.readAll("users", &us); err != nil {
+		return err
+	}
+	for _, u := range us {
+		gu := User{Username: u.Login, Name: u.Name}
+		for _, e := range u.Emails {
+			if gu.Email == "" || e.Primary {
+				gu.Email = e.Address
+			}
+		}
+		s.users[u.Login] = gu
+	}
+
+	var ms []githubMilestone
+	if err := s.readAll("milestones", &ms); err != nil {
+		return err
+	}
+	for _, m := range ms {
+		s.milestones[m.URL] = m.Title
+	}
+
+	var as []githubAttachment
+	if err := s.readAll("attachments", &as); err != nil {
+		return err
+	}
+	for _, a := range as {
+		s.attachments[a.Issue] = append(s.attachments[a.Issue], a)
+	}
+
+	var is []githubIssue
+	if err := s.readAll("issues", &is); err != nil {
+		return err
+	}
+	for _, gi := range is {
+		k := githubKey(gi.URL)
+		repo := strings.SplitN(k, "#", 2)[0]
+		i := &exportIssue{
+			Issue: Issue{
+				JiraID:      gi.URL,
+				JiraKey:     k,
+				URL:         gi.URL,
+				Title:       fmt.Sprintf("%s:%s", k, gi.Title),
+				Description: qualifyReferences(repo, gi.Body),
+				Status:      "Open",
+				CreatorID:   login(gi.User),
+				Labels:      []string{},
+				CreatedAt:   gi.CreatedAt,
+				UpdatedAt:   gi.UpdatedAt,
+				Comments:    Comments{},
+			},
+			Project: gi.Repository,
+		}
+		if gi.ClosedAt != nil {
+			i.Status = "Closed"
+		}
+		// gitlab takes a single assignee from us
+		if len(gi.Assignees) != 0 {
+			i.Assignee = login(gi.Assignees[0])
+		} else if gi.Assignee != "" {
+			i.Assignee = login(gi.Assignee)
+		}
+		// github labels are labels already, the label mapping can still rename them
+		for _, l := range gi.Labels {
+			n := l[strings.LastIndex(l, "/")+1:]
+			if un, err := url.PathUnescape(n); err == nil {
+				n = un
+			}
+			i.Labels = append(i.Labels, n)
+		}
+		i.JiraLabels = i.Labels
+		if m, ok := s.milestones[gi.Milestone]; ok {
+			i.FixVersions = []string{m}
+		}
+		for x, a := range s.attachments[gi.URL] {
+			i.Attachments = append(i.Attachments, exportAttachment{
+				ID:      fmt.Sprintf("%s/%d", gi.URL, x+1),
+				Name:    a.AssetName,
+				Author:  login(a.User),
+				Created: a.CreatedAt,
+			})
+		}
+		if _, ok := s.issues[i.JiraID]; !ok {
+			s.order = append(s.order, i.JiraID)
+		}
+		s.issues[i.JiraID] = i
+		s.keys[k] = true
+	}
+
+	// comments of pull requests name no issue, those are left out
+	var cs []githubComment
+	if err := s.readAll("issue_comments", &cs); err != nil {
+		return err
+	}
+	for _, c := range cs {
+		i, ok := s.issues[c.Issue]
+		if !ok {
+			continue
+		}
+		i.Comments = append(i.Comments, Comment{
+			JiraID:    c.URL[strings.LastIndex(c.URL, "-")+1:],
+			Body:      qualifyReferences(strings.SplitN(i.JiraKey, "#", 2)[0], c.Body),
+			CreatorID: login(c.User),
+			CreatedAt: c.CreatedAt,
+		})
+	}
+	return nil
+}
+
+// login returns the login of the github user with profile url u
+func login(u string) string {
+	return u[strings.LastIndex(u, "/")+1:]
+}
+
+// githubKey names issue url u the way github references it, owner/repo#number
+func githubKey(u string) string {
+	p, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	ps := strings.Split(strings.Trim(p.Path, "/"), "/")
+	if len(ps) != 4 {
+		return u
+	}
+	return fmt.Sprintf("%s/%s#%s", ps[0], ps[1], ps[3])
+}
+
+// bareReferencePattern matches #number, github's reference to an issue or pull request of the same repository
+var bareReferencePattern = regexp.MustCompile(`(^|[^\w&/#])#([0-9]+)\b`)
+
+// qualifyReferences turns the #number references in t into owner/repo#number, repo being the repository
+// t was written in. that way they can be rewritten the way references to other repositories are
+func qualifyReferences(repo, t string) string {
+	return bareReferencePattern.ReplaceAllString(t, "${1}"+repo+"#${2}")
+}
+
+// has tells if the archive holds the issue github knows as k, owner/repo#number. pull requests share
+// the numbering but are not in it
+func (s *githubSource) has(k string) bool {
+	return s.keys[k]
+}
+
+// IssueIDs selects the issues of the repository named c.Name. the name, not owner/name,
+// it names the migration state as well
+func (s *githubSource) IssueIDs(c ProjectConfig, since time.Time) ([]string, error) {
+	var repo string
+	for _, r := range s.repos {
+		if strings.EqualFold(r.Name, c.Name) {
+			if repo != "" {
+				return nil, fmt.Errorf("more than one repository named %s in the github archive", c.Name)
+			}
+			repo = r.URL
+		}
+	}
+	if repo == "" {
+		return nil, fmt.Errorf("no repository named %s in the github archive", c.Name)
+	}
+	if c.JQL != "" {
+		contextLogger.Warnf("jql can't be applied to a github archive, ignoring: %s", c.JQL)
+	}
+
+	var ids []string
+	for _, id := range s.order {
+		i := s.issues[id]
+		switch {
+		case i.Project != repo:
+		case !since.IsZero() && i.UpdatedAt.Before(since):
+		case !matches(statuses, i.Status), !matches(issueKeys, i.JiraKey):
+		default:
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// Issue returns issue id with its attachments copied out of the archive
+func (s *githubSource) Issue(id string) (Issue, error) {
+	ei, ok := s.issues[id]
+	if !ok {
+		return Issue{}, fmt.Errorf("issue %s is not in the github archive", id)
+	}
+
+	i := ei.Issue
+	i.Attachements = Attachements{}
+	for x, a := range s.attachments[id] {
+		ea := ei.Attachments[x]
+		tf, err := s.copyAttachment(a)
+		if err != nil {
+			contextLogger.WithError(err).Errorf("unable to retrieve attachment %s of %s", a.AssetName, i.JiraKey)
+			continue
+		}
+		i.Attachements = append(i.Attachements, Attachement{
+			JiraID:    ea.ID,
+			FileName:  tf,
+			CreatorID: ea.Author,
+			CreatedAt: ea.Created,
+		})
+	}
+	return i, nil
+}
+
+// copyAttachment copies attachment a, which the archive refers to as tarball://root/<path>, to the tmp dir
+func (s *githubSource) copyAttachment(a githubAttachment) (string, error) {
+	p := strings.TrimPrefix(a.AssetURL, "tarball://root/")
+	if p == a.AssetURL {
+		return "", fmt.Errorf("attachment %s is not in the archive", a.AssetURL)
+	}
+	in, err := os.Open(filepath.Join(s.dir, filepath.FromSlash(path.Clean("/"+p))))
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	tf := utils.GetTmpDirFileName(a.AssetName)
+	out, err := os.Create(tf)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return "", err
+	}
+	return tf, out.Close()
+}
+
+// User returns github user n, users without a known address get their github noreply address
+func (s *githubSource) User(n string) (User, error) {
+	if n == "" {
+		return User{}, fmt.Errorf("no user name")
+	}
+	u, ok := s.users[n]
+	if !ok {
+		u = User{Username: n}
+	}
+	if u.Name == "" {
+		u.Name = n
+	}
+	if u.Email == "" {
+		u.Email = fmt.Sprintf("%s@users.noreply.github.com", n)
+	}
+	return u, nil
+}
+
+// untar extracts the regular files and directories of gzipped tarball f into d
+func untar(f, d string) error {
+	fh, err := os.Open(f)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	gz, err := gzip.NewReader(fh)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// entries can't escape d
+		t := filepath.Join(d, filepath.FromSlash(path.Clean("/"+h.Name)))
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(t, 0770); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(t), 0770); err != nil {
+				return err
+			}
+			out, err := os.Create(t)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package migrate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/wianvos/pigmy/cmd/store"
+	utils "github.com/wianvos/pigmy/cmd/utils"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// gitlabRequest is a request the fake gitlab received
+type gitlabRequest struct {
+	Method string
+	Path   string
+	Sudo   string
+	Query  map[string][]string
+	Body   map[string]interface{}
+}
+
+// fakeGitlab answers the requests matching a route with what its handler returns and records all of them.
+// routes are keyed on method and path pattern, GET projects/*/issues, what matches no route is not found
+type fakeGitlab struct {
+	mu       sync.Mutex
+	requests []gitlabRequest
+	routes   map[string]func(r gitlabRequest) (int, interface{})
+}
+
+func newFakeGitlab() *fakeGitlab {
+	return &fakeGitlab{routes: make(map[string]func(gitlabRequest) (int, interface{}))}
+}
+
+// route has requests matching method and path pattern p answered by h
+func (f *fakeGitlab) route(method, p string, h func(r gitlabRequest) (int, interface{})) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.routes[method+" "+p] = h
+}
+
+// received returns the requests matching method and path pattern p
+func (f *fakeGitlab) received(method, p string) []gitlabRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var rs []gitlabRequest
+	for _, r := range f.requests {
+		if ok, _ := path.Match(p, r.Path); ok && r.Method == method {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+func (f *fakeGitlab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	gr := gitlabRequest{
+		Method: r.Method,
+		Path:   strings.TrimPrefix(r.URL.Path, "/api/v4/"),
+		Sudo:   r.Header.Get("Sudo"),
+		Query:  r.URL.Query(),
+		Body:   make(map[string]interface{}),
+	}
+	if b, _ := ioutil.ReadAll(r.Body); len(b) != 0 {
+		json.Unmarshal(b, &gr.Body)
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, gr)
+	var h func(gitlabRequest) (int, interface{})
+	for k, rh := range f.routes {
+		kp := strings.SplitN(k, " ", 2)
+		if ok, _ := path.Match(kp[1], gr.Path); ok && kp[0] == gr.Method {
+			h = rh
+			break
+		}
+	}
+	f.mu.Unlock()
+
+	if h == nil {
+		reply(w, http.StatusNotFound, map[string]string{"message": "404 Not Found"})
+		return
+	}
+	st, v := h(gr)
+	reply(w, st, v)
+}
+
+// ok answers with v
+func ok(v interface{}) func(gitlabRequest) (int, interface{}) {
+	return func(gitlabRequest) (int, interface{}) { return http.StatusOK, v }
+}
+
+// testGitlab starts a fake gitlab the gitlab client talks to and returns a project migrating to project 1 in it
+func testGitlab(t *testing.T) (*fakeGitlab, *Project, func()) {
+	d, err := ioutil.TempDir("", "pigmy-gitlab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("stateDir", d)
+	viper.Set("jiraURL", "https://jira.example.com")
+	viper.Set("authorship", authorshipSudo)
+	viper.Set("quietNotifications", false)
+
+	f := newFakeGitlab()
+	srv := httptest.NewServer(f)
+	glc := gitlab.NewClient(srv.Client(), "secret")
+	glc.SetBaseURL(srv.URL + "/api/v4")
+	utils.GitlabClient = glc
+	Reset()
+	if err := loadAuthorship(); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := store.Load("PIG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Project{
+		Pid:       1,
+		Name:      "PIG",
+		Config:    ProjectConfig{Name: "PIG"},
+		Mapping:   st,
+		StartedAt: time.Now(),
+		access:    make(map[string]gitlab.AccessLevelValue),
+	}
+
+	return f, p, func() {
+		srv.Close()
+		utils.GitlabClient = nil
+		Reset()
+		os.RemoveAll(d)
+	}
+}
+
+func TestCreateIssueBackdates(t *testing.T) {
+	tests := []struct {
+		name    string
+		admin   bool
+		sudo    string
+		headers bool
+	}{
+		{"admin author", true, "jdoe", false},
+		{"author without admin rights", false, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, p, done := testGitlab(t)
+			defer done()
+
+			f.route("GET", "users", ok([]gitlab.User{{ID: 2, Username: "jdoe", IsAdmin: tt.admin}}))
+			f.route("GET", "projects/1/issues", ok([]gitlab.Issue{}))
+			f.route("POST", "projects/1/issues", ok(gitlab.Issue{ID: 10, IID: 3}))
+
+			created := time.Date(2018, 4, 3, 8, 15, 0, 0, time.UTC)
+			i := &Issue{JiraID: "10001", JiraKey: "PIG-1", Title: "PIG-1:first", Description: "text", Status: "Open", CreatorID: "jdoe", Assignee: "jdoe", CreatedAt: created}
+			if err := i.Create(p); err != nil {
+				t.Fatal(err)
+			}
+
+			rs := f.received("POST", "projects/1/issues")
+			if len(rs) != 1 {
+				t.Fatalf("%d issues created", len(rs))
+			}
+			r := rs[0]
+			if ca, _ := time.Parse(time.RFC3339, r.Body["created_at"].(string)); !ca.Equal(created) {
+				t.Errorf("created_at sent as %v", r.Body["created_at"])
+			}
+			if r.Sudo != tt.sudo {
+				t.Errorf("posted with sudo %q, want %q", r.Sudo, tt.sudo)
+			}
+			if h := strings.HasPrefix(r.Body["description"].(string), "*Originally created by @jdoe"); h != tt.headers {
+				t.Errorf("description %q", r.Body["description"])
+			}
+			if m := p.Mapping.Issue("10001"); m == nil || m.IID != 3 {
+				t.Errorf("mapping %+v", m)
+			}
+		})
+	}
+}
+
+func TestUpdateAfterWriteBack(t *testing.T) {
+	wrote := time.Date(2018, 4, 5, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		updated time.Time
+		update  bool
+	}{
+		{"only written back", wrote, false},
+		{"updated since", wrote.Add(time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, p, done := testGitlab(t)
+			defer done()
+
+			f.route("GET", "users", ok([]gitlab.User{{ID: 2, Username: "jdoe", IsAdmin: true}}))
+			f.route("PUT", "projects/1/issues/3", ok(gitlab.Issue{ID: 10, IID: 3}))
+			f.route("POST", "projects/1/issues/3/notes", ok(gitlab.Note{ID: 50}))
+
+			// the migration saw comment 1, the write back added comment 2
+			p.Mapping.SetIssue(&store.Issue{
+				JiraID:    "10001",
+				JiraKey:   "PIG-1",
+				IID:       3,
+				Comments:  map[string]int{"1": 40, "2": 0},
+				WriteBack: &store.WriteBack{CommentID: "2", Updated: wrote},
+			})
+			i := &Issue{JiraID: "10001", JiraKey: "PIG-1", Title: "PIG-1:first", Status: "Open", CreatorID: "jdoe", UpdatedAt: tt.updated, Comments: Comments{
+				{JiraID: "1", Body: "a comment", CreatorID: "jdoe"},
+				{JiraID: "2", Body: "This issue has been migrated to GitLab", CreatorID: "jdoe"},
+			}}
+			if err := i.Create(p); err != nil {
+				t.Fatal(err)
+			}
+
+			if n := len(f.received("PUT", "projects/1/issues/3")); (n == 1) != tt.update {
+				t.Errorf("issue updated %d times", n)
+			}
+			if n := len(f.received("POST", "projects/1/issues/3/notes")); n != 0 {
+				t.Errorf("%d notes added, the write back comment came back", n)
+			}
+		})
+	}
+}
+
+func TestSyncCommentSkipsWriteBack(t *testing.T) {
+	f, p, done := testGitlab(t)
+	defer done()
+
+	p.Mapping.SetIssue(&store.Issue{JiraID: "10001", JiraKey: "PIG-1", IID: 3, WriteBack: &store.WriteBack{CommentID: "2"}})
+	if err := p.SyncComment("10001", "2", "This issue has been migrated to GitLab"); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.received("PUT", "*")) != 0 || len(f.received("POST", "*")) != 0 {
+		t.Error("the write back comment was synced")
+	}
+}
+
+func TestUserCreateQuietsMember(t *testing.T) {
+	f, p, done := testGitlab(t)
+	defer done()
+	viper.Set("quietNotifications", true)
+	ExitOnSignal = false
+	defer func() { ExitOnSignal = true }()
+	if _, err := BeginRun("test"); err != nil {
+		t.Fatal(err)
+	}
+	defer EndRun()
+
+	f.route("GET", "users", ok([]gitlab.User{{ID: 2, Username: "jdoe"}}))
+	f.route("POST", "projects/1/members", func(gitlabRequest) (int, interface{}) {
+		return http.StatusCreated, gitlab.ProjectMember{ID: 2, Username: "jdoe"}
+	})
+	// a private project is hidden from whoever is not a member
+	f.route("GET", "projects/1/notification_settings", func(r gitlabRequest) (int, interface{}) {
+		if len(f.received("POST", "projects/1/members")) == 0 {
+			return http.StatusNotFound, map[string]string{"message": "404 Project Not Found"}
+		}
+		return http.StatusOK, map[string]string{"level": "watch"}
+	})
+	f.route("PUT", "projects/1/notification_settings", ok(map[string]string{"level": "disabled"}))
+
+	u := &User{Username: "jdoe", Name: "John Doe"}
+	if err := u.Create(p); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := f.received("PUT", "projects/1/notification_settings")
+	if len(rs) != 1 {
+		t.Fatalf("notification settings changed %d times", len(rs))
+	}
+	if rs[0].Sudo != "2" || rs[0].Body["level"] != "disabled" {
+		t.Errorf("notification settings changed to %v as %q", rs[0].Body, rs[0].Sudo)
+	}
+}
+
+func TestCleanupAbandonedRuns(t *testing.T) {
+	f, _, done := testGitlab(t)
+	defer done()
+	f.route("PUT", "projects/1/notification_settings", ok(map[string]string{"level": "watch"}))
+
+	// a run whose process is long gone, it left a notification setting behind
+	r, err := store.NewRun("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.PID = 1 << 22
+	r.Notifications = []*store.Notification{{Username: "jdoe", UserID: 2, ProjectID: 1, Level: "watch"}}
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Abandoned() {
+		t.Skip("the pid of the abandoned run is in use")
+	}
+
+	cleanupAbandonedRuns()
+	if n := len(f.received("PUT", "projects/1/notification_settings")); n != 1 {
+		t.Errorf("notification settings restored %d times", n)
+	}
+	lr, err := store.LoadRun(r.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lr.Finished.IsZero() || lr.Abandoned() {
+		t.Error("the cleaned up run is still abandoned")
+	}
+
+	// the next start leaves it alone
+	cleanupAbandonedRuns()
+	if n := len(f.received("PUT", "projects/1/notification_settings")); n != 1 {
+		t.Errorf("notification settings restored %d times", n)
+	}
+}
+
+func TestResolveReferencesOfEarlierRuns(t *testing.T) {
+	f, p, done := testGitlab(t)
+	defer done()
+	f.route("GET", "users", ok([]gitlab.User{{ID: 2, Username: "jdoe", IsAdmin: true}}))
+	f.route("PUT", "projects/1/issues/3", ok(gitlab.Issue{ID: 10, IID: 3}))
+
+	// an earlier run referenced DOG-1 before DOG was migrated, this run fetched nothing
+	p.Mapping.SetIssue(&store.Issue{JiraID: "10001", JiraKey: "PIG-1", IID: 3, PendingRefs: true})
+	p.Source = &exportSource{issues: map[string]*exportIssue{
+		"10001": {Issue: Issue{JiraID: "10001", JiraKey: "PIG-1", Description: "see DOG-1", CreatorID: "jdoe"}},
+	}}
+	dog, err := store.Load("DOG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dog.GitlabPID, dog.GitlabPath = 2, "team/dog"
+	dog.SetIssue(&store.Issue{JiraID: "20001", JiraKey: "DOG-1", IID: 5})
+	if err := dog.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	p.ResolveForwardReferences()
+
+	rs := f.received("PUT", "projects/1/issues/3")
+	if len(rs) != 1 || !strings.Contains(rs[0].Body["description"].(string), "team/dog#5") {
+		t.Fatalf("references rewritten as %v", rs)
+	}
+	if p.Mapping.Issue("10001").PendingRefs {
+		t.Error("the reference to DOG-1 is still pending")
+	}
+}
+
+func TestAddIssueTemplateBranch(t *testing.T) {
+	f, _, done := testGitlab(t)
+	defer done()
+	const files = "projects/1/repository/files/.gitlab/issue_templates/*"
+	f.route("POST", files, ok(map[string]string{"file_path": ".gitlab/issue_templates/bug.md"}))
+
+	tf, err := ioutil.TempFile("", "pigmy-template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf.WriteString("## steps to reproduce")
+	tf.Close()
+	defer os.Remove(tf.Name())
+
+	for _, b := range []string{"main", ""} {
+		if err := addIssueTemplate(&gitlab.Project{ID: 1, DefaultBranch: b}, "bug", tf.Name()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rs := f.received("POST", files)
+	if len(rs) != 2 || rs[0].Body["branch"] != "main" || rs[1].Body["branch"] != templateBranch {
+		t.Errorf("templates committed as %v", rs)
+	}
+}
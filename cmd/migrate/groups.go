@@ -0,0 +1,307 @@
+package migrate
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/wianvos/pigmy/cmd/store"
+	utils "github.com/wianvos/pigmy/cmd/utils"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// GroupsConfig holds the settings of a jira group synchronisation, the groups section of the config
+type GroupsConfig struct {
+	// Parent is the full path of the gitlab group the groups are created in, top level when empty
+	Parent string `json:"parent,omitempty"`
+	// Access is the gitlab access level group members get
+	Access string `json:"access,omitempty"`
+	// Visibility of new gitlab groups: private, internal or public
+	Visibility string `json:"visibility,omitempty"`
+	// Paths maps jira group names to gitlab group paths, derived from the name when not listed
+	Paths map[string]string `json:"paths,omitempty"`
+	// Prune removes gitlab group members that are not in the jira group
+	Prune bool `json:"prune,omitempty"`
+}
+
+var groupsFlags GroupsConfig
+
+// groupResult is the outcome of synchronising a single group
+type groupResult struct {
+	Group   string
+	Path    string
+	Members int
+	Added   int
+	Removed int
+	Err     error
+}
+
+// create the command and add it to the migrateCMD objects
+func addGroups() {
+	cmd := &cobra.Command{
+		Use:   "groups [jira group]...",
+		Short: "create or update gitlab groups with the members of jira groups, all jira groups when none are given",
+		Run:   runGroups,
+	}
+
+	cmd.Flags().StringVar(&groupsFlags.Parent, "parent", "", "full path of the gitlab group to create the groups in")
+	cmd.Flags().StringVar(&groupsFlags.Access, "access", "", "gitlab access level of group members (defaults to developer)")
+	cmd.Flags().StringVar(&groupsFlags.Visibility, "visibility", "", "visibility of new gitlab groups (defaults to private)")
+	cmd.Flags().BoolVar(&groupsFlags.Prune, "prune", false, "remove gitlab group members that are not in the jira group")
+
+	migrateCMD.AddCommand(cmd)
+}
+
+func runGroups(cmd *cobra.Command, args []string) {
+
+	contextLogger = contextLogger.WithFields(log.Fields{"subcommand": "Groups"})
+
+	c := GroupsConfig{}
+	if err := viper.UnmarshalKey("groups", &c); err != nil {
+		contextLogger.WithError(err).Error("unable to read the groups section of the config")
+		fmt.Println("unable to read the groups section of the config")
+		os.Exit(2)
+	}
+	c = groupsFlags.merge(c)
+
+	l, err := accessLevel(c.Access)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	gs := args
+	if len(gs) == 0 {
+		if gs, err = jiraGroups(); err != nil {
+			contextLogger.WithError(err).Error("unable to list jira groups")
+			fmt.Printf("unable to list jira groups: %s\n", err)
+			os.Exit(2)
+		}
+	}
+
+	var parent *gitlab.Group
+	if c.Parent != "" {
+		glc := utils.GetGitlabClient()
+		if parent, _, err = glc.Groups.GetGroup(c.Parent); err != nil {
+			contextLogger.WithError(err).Error("unable to find the parent group")
+			fmt.Printf("unable to find gitlab group %s: %s\n", c.Parent, err)
+			os.Exit(2)
+		}
+	}
+
+	if _, err := BeginRun("migrate groups"); err != nil {
+		contextLogger.WithError(err).Error("unable to record the run")
+		fmt.Printf("unable to record the run: %s .. exiting\n", err)
+		os.Exit(2)
+	}
+
+	fmt.Printf("synchronising %d groups\n", len(gs))
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JIRA GROUP\tGITLAB GROUP\tMEMBERS\tADDED\tREMOVED\tRESULT")
+	for _, g := range gs {
+		r := c.syncGroup(g, parent, l)
+		res := "ok"
+		if r.Err != nil {
+			failed++
+			res = r.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n", r.Group, r.Path, r.Members, r.Added, r.Removed, res)
+	}
+	w.Flush()
+
+	if failed != 0 {
+		exit(1)
+	}
+	EndRun()
+}
+
+// merge fills the settings left empty from defaults d
+func (c GroupsConfig) merge(d GroupsConfig) GroupsConfig {
+	if c.Parent == "" {
+		c.Parent = d.Parent
+	}
+	if c.Access == "" {
+		c.Access = d.Access
+	}
+	if c.Access == "" {
+		c.Access = "developer"
+	}
+	if c.Visibility == "" {
+		c.Visibility = d.Visibility
+	}
+	if c.Visibility == "" {
+		c.Visibility = "private"
+	}
+	c.Paths = mergeMap(d.Paths, c.Paths)
+	c.Prune = c.Prune || d.Prune
+	return c
+}
+
+var groupPathPattern = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// path returns the gitlab path of jira group g
+func (c GroupsConfig) path(g string) string {
+	if p, ok := c.Paths[g]; ok {
+		return p
+	}
+	return strings.Trim(groupPathPattern.ReplaceAllString(strings.ToLower(g), "-"), "-.")
+}
+
+// syncGroup makes sure the gitlab group of jira group g exists and holds its members
+func (c GroupsConfig) syncGroup(g string, parent *gitlab.Group, l gitlab.AccessLevelValue) groupResult {
+	contextLogger := contextLogger.WithField("group", g)
+	glc := utils.GetGitlabClient()
+	jlc := utils.GetJiraClient()
+
+	r := groupResult{Group: g, Path: c.path(g)}
+	if parent != nil {
+		r.Path = parent.FullPath + "/" + r.Path
+	}
+
+	gg, resp, err := glc.Groups.GetGroup(r.Path)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		r.Err = err
+		return r
+	}
+	if gg == nil {
+		n, pa := g, c.path(g)
+		vv := gitlab.VisibilityValue(c.Visibility)
+		o := &gitlab.CreateGroupOptions{Name: &n, Path: &pa, Visibility: &vv}
+		if parent != nil {
+			o.ParentID = &parent.ID
+		}
+		t := time.Now()
+		gg, _, err = glc.Groups.CreateGroup(o)
+		if err != nil {
+			journal(store.ActionCreate, &store.Object{Kind: store.ObjectGroup, Group: r.Path, Name: g}, t, err)
+			r.Err = fmt.Errorf("unable to create group: %s", err)
+			return r
+		}
+		journal(store.ActionCreate, &store.Object{Kind: store.ObjectGroup, ID: gg.ID, Group: gg.FullPath, Name: g}, t, nil)
+		contextLogger.Infof("group %s created", gg.FullPath)
+	}
+
+	jms, err := jiraGroupMembers(jlc, g)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	current, err := groupMembers(gg.ID)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	// a member we failed to look up isn't in keep, pruning would throw them out
+	keep := make(map[int]bool)
+	complete := true
+	for _, jm := range jms {
+		if jm.Name == "admin" || jm.Name == "root" {
+			continue
+		}
+		u, err := jiraGetUser(jm.Name)
+		if err != nil {
+			contextLogger.WithError(err).Errorf("unable to retrieve jira user %s", jm.Name)
+			r.Err = fmt.Errorf("unable to retrieve jira user %s", jm.Name)
+			complete = false
+			continue
+		}
+		cu, err := u.ensure()
+		if err != nil {
+			r.Err = fmt.Errorf("unable to create gitlab user %s", u.Username)
+			complete = false
+			continue
+		}
+		keep[cu.ID] = true
+		r.Members++
+
+		m, ok := current[cu.ID]
+		switch {
+		case !ok:
+			t := time.Now()
+			_, _, err = glc.GroupMembers.AddGroupMember(gg.ID, &gitlab.AddGroupMemberOptions{UserID: &cu.ID, AccessLevel: &l})
+			journal(store.ActionCreate, &store.Object{Kind: store.ObjectMember, ID: cu.ID, Group: gg.FullPath}, t, err)
+			if err == nil {
+				r.Added++
+			}
+		case m.AccessLevel != l && m.AccessLevel != gitlab.OwnerPermissions:
+			_, _, err = glc.GroupMembers.EditGroupMember(gg.ID, cu.ID, &gitlab.EditGroupMemberOptions{AccessLevel: &l})
+		}
+		if err != nil {
+			contextLogger.WithError(err).Errorf("unable to add %s to the group", u.Username)
+			r.Err = fmt.Errorf("unable to add %s to the group", u.Username)
+		}
+	}
+
+	if !c.Prune {
+		return r
+	}
+	if !complete {
+		contextLogger.Warnf("not pruning group %s, some of its jira members could not be looked up", gg.FullPath)
+		return r
+	}
+	for id, m := range current {
+		// owners are left alone, removing them might lock us out of the group
+		if keep[id] || m.AccessLevel == gitlab.OwnerPermissions {
+			continue
+		}
+		if _, err := glc.GroupMembers.RemoveGroupMember(gg.ID, id); err != nil {
+			contextLogger.WithError(err).Errorf("unable to remove %s from the group", m.Username)
+			r.Err = fmt.Errorf("unable to remove %s from the group", m.Username)
+			continue
+		}
+		r.Removed++
+	}
+
+	return r
+}
+
+// groupMembers returns the direct members of gitlab group gid by user id
+func groupMembers(gid int) (map[int]*gitlab.GroupMember, error) {
+	glc := utils.GetGitlabClient()
+
+	ms := make(map[int]*gitlab.GroupMember)
+	o := &gitlab.ListGroupMembersOptions{ListOptions: gitlab.ListOptions{PerPage: 100, Page: 1}}
+	for {
+		l, resp, err := glc.Groups.ListGroupMembers(gid, o)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range l {
+			ms[m.ID] = m
+		}
+		if resp.NextPage == 0 {
+			return ms, nil
+		}
+		o.Page = resp.NextPage
+	}
+}
+
+// jiraGroups lists the names of all jira groups
+func jiraGroups() ([]string, error) {
+	jlc := utils.GetJiraClient()
+
+	r := struct {
+		Groups []struct {
+			Name string `json:"name"`
+		} `json:"groups"`
+	}{}
+	if err := jiraGet(jlc, "rest/api/2/groups/picker?maxResults=10000", &r); err != nil {
+		return nil, err
+	}
+
+	gs := make([]string, 0, len(r.Groups))
+	for _, g := range r.Groups {
+		gs = append(gs, g.Name)
+	}
+	return gs, nil
+}
@@ -0,0 +1,228 @@
+package migrate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	blankLines    = regexp.MustCompile(`\n{3,}`)
+	trailingSpace = regexp.MustCompile(`[ \t]+\n`)
+)
+
+// htmlToMarkdown converts the html jira renders descriptions and comments to (in xml exports)
+// into markdown, the way the live source ends up after translation. what has no markdown
+// counterpart is reduced to its text
+func htmlToMarkdown(s string) string {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	ns, err := html.ParseFragment(strings.NewReader(s), body)
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to parse html, keeping it as is")
+		return s
+	}
+
+	var b strings.Builder
+	for _, n := range ns {
+		b.WriteString(markdownNode(n))
+	}
+	md := trailingSpace.ReplaceAllString(b.String(), "\n")
+	md = blankLines.ReplaceAllString(md, "\n\n")
+	return strings.TrimSpace(md)
+}
+
+// markdownChildren converts the children of n
+func markdownChildren(n *html.Node) string {
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(markdownNode(c))
+	}
+	return b.String()
+}
+
+func markdownNode(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		// html collapses whitespace, markdown would take newlines for breaks
+		t := strings.Join(strings.Fields(n.Data), " ")
+		if t == "" {
+			if n.Data == "" {
+				return ""
+			}
+			return " "
+		}
+		// whitespace after a break or block would start the line with it
+		if strings.TrimLeft(n.Data, " \t\r\n") != n.Data && !breaks(n.PrevSibling) {
+			t = " " + t
+		}
+		if strings.TrimRight(n.Data, " \t\r\n") != n.Data {
+			t = t + " "
+		}
+		return t
+	case html.ElementNode:
+	default:
+		return ""
+	}
+
+	switch n.DataAtom {
+	case atom.P, atom.Div:
+		return "\n\n" + strings.TrimSpace(markdownChildren(n)) + "\n\n"
+	case atom.Br:
+		return "\n"
+	case atom.Hr:
+		return "\n\n---\n\n"
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		h := strings.Repeat("#", int(n.Data[1]-'0'))
+		return fmt.Sprintf("\n\n%s %s\n\n", h, strings.TrimSpace(markdownChildren(n)))
+	case atom.B, atom.Strong:
+		return emphasis(markdownChildren(n), "**")
+	case atom.I, atom.Em, atom.Cite:
+		return emphasis(markdownChildren(n), "_")
+	case atom.Del, atom.S, atom.Strike:
+		return emphasis(markdownChildren(n), "~~")
+	case atom.Code, atom.Tt:
+		return emphasis(htmlText(n), "`")
+	case atom.Pre:
+		// jira names the language in the class, code-java and friends
+		l := ""
+		for _, c := range strings.Fields(attr(n, "class")) {
+			if strings.HasPrefix(c, "code-") {
+				l = strings.TrimPrefix(c, "code-")
+			}
+		}
+		return fmt.Sprintf("\n\n```%s\n%s\n```\n\n", l, strings.Trim(htmlText(n), "\n"))
+	case atom.A:
+		return markdownLink(n)
+	case atom.Img:
+		return fmt.Sprintf("![%s](%s)", attr(n, "alt"), attr(n, "src"))
+	case atom.Ul, atom.Ol:
+		return "\n\n" + markdownList(n) + "\n\n"
+	case atom.Blockquote:
+		q := strings.TrimSpace(markdownChildren(n))
+		return "\n\n> " + strings.Replace(q, "\n", "\n> ", -1) + "\n\n"
+	case atom.Table:
+		return "\n\n" + markdownTable(n) + "\n\n"
+	case atom.Script, atom.Style:
+		return ""
+	}
+	return markdownChildren(n)
+}
+
+// breaks tells whether n ends the line
+func breaks(n *html.Node) bool {
+	if n == nil || n.Type != html.ElementNode {
+		return false
+	}
+	switch n.DataAtom {
+	case atom.P, atom.Div, atom.Br, atom.Hr, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6,
+		atom.Pre, atom.Ul, atom.Ol, atom.Blockquote, atom.Table:
+		return true
+	}
+	return false
+}
+
+// emphasis wraps text t in marker m, keeping the surrounding whitespace outside of it
+func emphasis(t, m string) string {
+	s := strings.TrimSpace(t)
+	if s == "" {
+		return t
+	}
+	l := t[:strings.Index(t, s)]
+	r := t[len(l)+len(s):]
+	return l + m + s + m + r
+}
+
+// markdownLink converts anchor n. links showing their own url, like the issue links jira renders,
+// stay plain so the references in them can be rewritten
+func markdownLink(n *html.Node) string {
+	t := strings.TrimSpace(markdownChildren(n))
+	h := attr(n, "href")
+	switch {
+	case h == "":
+		return t
+	case t == "" || t == h:
+		return h
+	case strings.HasSuffix(h, "/browse/"+t):
+		return t
+	}
+	return fmt.Sprintf("[%s](%s)", t, h)
+}
+
+// markdownList converts list n, nested lists are indented below their item
+func markdownList(n *html.Node) string {
+	var items []string
+	x := 0
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom != atom.Li {
+			continue
+		}
+		x++
+		m := "- "
+		if n.DataAtom == atom.Ol {
+			m = fmt.Sprintf("%d. ", x)
+		}
+		t := blankLines.ReplaceAllString(strings.TrimSpace(markdownChildren(c)), "\n")
+		t = strings.Replace(t, "\n\n", "\n", -1)
+		items = append(items, m+strings.Replace(t, "\n", "\n"+strings.Repeat(" ", len(m)), -1))
+	}
+	return strings.Join(items, "\n")
+}
+
+// markdownTable converts table n, its first row is taken for the header markdown insists on
+func markdownTable(n *html.Node) string {
+	var rows [][]string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom != atom.Tr {
+				walk(c)
+				continue
+			}
+			var r []string
+			for d := c.FirstChild; d != nil; d = d.NextSibling {
+				if d.DataAtom == atom.Td || d.DataAtom == atom.Th {
+					v := strings.Join(strings.Fields(markdownChildren(d)), " ")
+					r = append(r, strings.Replace(v, "|", "\\|", -1))
+				}
+			}
+			rows = append(rows, r)
+		}
+	}
+	walk(n)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	for x, r := range rows {
+		fmt.Fprintf(&b, "| %s |\n", strings.Join(r, " | "))
+		if x == 0 {
+			fmt.Fprintf(&b, "|%s\n", strings.Repeat(" --- |", len(r)))
+		}
+	}
+	return b.String()
+}
+
+// htmlText returns the text in n as is, for preformatted content
+func htmlText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(htmlText(c))
+	}
+	return b.String()
+}
+
+func attr(n *html.Node, k string) string {
+	for _, a := range n.Attr {
+		if a.Key == k {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -0,0 +1,269 @@
+package migrate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	jira "github.com/wianvos/go-jira"
+	"github.com/wianvos/pigmy/cmd/store"
+	utils "github.com/wianvos/pigmy/cmd/utils"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// MembershipConfig maps the jira project roles and permissions of a user to a gitlab access level.
+// a user gets the highest level any of its roles or permissions maps to, and Default when none do
+type MembershipConfig struct {
+	// Roles maps jira project role names to gitlab access levels
+	Roles map[string]string `json:"roles,omitempty"`
+	// Permissions maps jira permission keys (ADMINISTER_PROJECTS, ...) of the project permission scheme to gitlab access levels
+	Permissions map[string]string `json:"permissions,omitempty"`
+	// Default is the access level of users without any mapped role or permission
+	Default string `json:"default,omitempty"`
+	// Group is the full path of a gitlab group users are added to instead of the project
+	Group string `json:"group,omitempty"`
+}
+
+// defaultMembership is used when the config has no members section
+var defaultMembership = MembershipConfig{
+	Roles: map[string]string{
+		"Administrators": "maintainer",
+		"Developers":     "developer",
+		"Users":          "reporter",
+	},
+	Default: "reporter",
+}
+
+// accessLevels are the names the config uses for gitlab access levels
+var accessLevels = map[string]gitlab.AccessLevelValue{
+	"guest":      gitlab.GuestPermissions,
+	"reporter":   gitlab.ReporterPermissions,
+	"developer":  gitlab.DeveloperPermissions,
+	"maintainer": gitlab.MaintainerPermissions,
+	"master":     gitlab.MaintainerPermissions,
+	"owner":      gitlab.OwnerPermissions,
+}
+
+func accessLevel(n string) (gitlab.AccessLevelValue, error) {
+	l, ok := accessLevels[strings.ToLower(n)]
+	if !ok {
+		return gitlab.NoPermissions, fmt.Errorf("unknown access level %s", n)
+	}
+	return l, nil
+}
+
+// members returns the membership config of the project
+func (c ProjectConfig) members() MembershipConfig {
+	if c.Members == nil {
+		return defaultMembership
+	}
+	m := *c.Members
+	if m.Default == "" {
+		m.Default = defaultMembership.Default
+	}
+	return m
+}
+
+// projectRole is a jira project role with the users and groups in it
+type projectRole struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Actors []struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"actors"`
+}
+
+// permissionScheme is the jira permission scheme of a project with its grants
+type permissionScheme struct {
+	Permissions []struct {
+		Permission string `json:"permission"`
+		Holder     struct {
+			Type      string `json:"type"`
+			Parameter string `json:"parameter"`
+		} `json:"holder"`
+	} `json:"permissions"`
+}
+
+// accessLevels works out the gitlab access level of every jira user holding a mapped role or permission
+func (p *Project) accessLevels() (map[string]gitlab.AccessLevelValue, error) {
+	jlc := utils.GetJiraClient()
+	mc := p.Config.members()
+	a := make(map[string]gitlab.AccessLevelValue)
+
+	// raise gives users the level if it beats what they already have
+	raise := func(us []string, n string) error {
+		l, err := accessLevel(n)
+		if err != nil {
+			return err
+		}
+		for _, u := range us {
+			if l > a[u] {
+				a[u] = l
+			}
+		}
+		return nil
+	}
+
+	roles := make(map[string]string)
+	if err := jiraGet(jlc, fmt.Sprintf("rest/api/2/project/%s/role", p.Name), &roles); err != nil {
+		return nil, err
+	}
+	// role holders of the permission scheme refer to roles by id
+	roleUsers := make(map[string][]string)
+	for n, u := range roles {
+		r := projectRole{}
+		if err := jiraGet(jlc, u, &r); err != nil {
+			return nil, err
+		}
+		us, err := r.users(jlc)
+		if err != nil {
+			return nil, err
+		}
+		roleUsers[fmt.Sprint(r.ID)] = us
+		if l, ok := mc.Roles[n]; ok {
+			if err := raise(us, l); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(mc.Permissions) == 0 {
+		return a, nil
+	}
+	ps := permissionScheme{}
+	if err := jiraGet(jlc, fmt.Sprintf("rest/api/2/project/%s/permissionscheme?expand=permissions", p.Name), &ps); err != nil {
+		return nil, err
+	}
+	for _, g := range ps.Permissions {
+		l, ok := mc.Permissions[g.Permission]
+		if !ok {
+			continue
+		}
+		var us []string
+		switch g.Holder.Type {
+		case "user":
+			us = []string{g.Holder.Parameter}
+		case "group":
+			var err error
+			if us, err = groupUsers(jlc, g.Holder.Parameter); err != nil {
+				return nil, err
+			}
+		case "projectRole":
+			us = roleUsers[g.Holder.Parameter]
+		default:
+			// anyone, reporter, assignee and friends don't name anybody in particular
+			continue
+		}
+		if err := raise(us, l); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// users lists the jira users in the role, including those in its groups
+func (r projectRole) users(jlc *jira.Client) ([]string, error) {
+	var us []string
+	for _, a := range r.Actors {
+		switch a.Type {
+		case "atlassian-user-role-actor":
+			us = append(us, a.Name)
+		case "atlassian-group-role-actor":
+			gu, err := groupUsers(jlc, a.Name)
+			if err != nil {
+				return nil, err
+			}
+			us = append(us, gu...)
+		}
+	}
+	return us, nil
+}
+
+func groupUsers(jlc *jira.Client, g string) ([]string, error) {
+	ms, err := jiraGroupMembers(jlc, g)
+	if err != nil {
+		return nil, err
+	}
+	us := make([]string, 0, len(ms))
+	for _, m := range ms {
+		us = append(us, m.Name)
+	}
+	return us, nil
+}
+
+// jiraGroupMembers retrieves every member of jira group g, jira hands them out a page at a time
+func jiraGroupMembers(jlc *jira.Client, g string) ([]jira.GroupMember, error) {
+	var ms []jira.GroupMember
+	o := &jira.GroupSearchOptions{MaxResults: 50}
+	for {
+		l, _, err := jlc.Group.GetWithOptions(g, o)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve jira group %s: %s", g, err)
+		}
+		ms = append(ms, l...)
+		if len(l) < o.MaxResults {
+			return ms, nil
+		}
+		o.StartAt = o.StartAt + len(l)
+	}
+}
+
+// accessLevel returns the gitlab access level of jira user u
+func (p *Project) accessLevel(u string) (gitlab.AccessLevelValue, error) {
+	if p.access == nil {
+		a, err := p.accessLevels()
+		if err != nil {
+			contextLogger.WithError(err).Error("unable to read the jira project roles, everybody gets the default access level")
+			a = make(map[string]gitlab.AccessLevelValue)
+		}
+		p.access = a
+	}
+	if l, ok := p.access[u]; ok {
+		return l, nil
+	}
+	return accessLevel(p.Config.members().Default)
+}
+
+// addMember gives gitlab user uid access level l to the project, or to the configured parent group.
+// existing memberships are left alone
+func (p *Project) addMember(uid int, l gitlab.AccessLevelValue) error {
+	glc := utils.GetGitlabClient()
+
+	o := &store.Object{Kind: store.ObjectMember, ID: uid, JiraProject: p.Name}
+	t := time.Now()
+
+	var resp *gitlab.Response
+	var err error
+	if g := p.Config.members().Group; g != "" {
+		o.Group = g
+		_, resp, err = glc.GroupMembers.AddGroupMember(g, &gitlab.AddGroupMemberOptions{
+			UserID:      &uid,
+			AccessLevel: &l,
+		})
+	} else {
+		o.ProjectID = p.Pid
+		_, resp, err = glc.ProjectMembers.AddProjectMember(p.Pid, &gitlab.AddProjectMemberOptions{
+			UserID:      &uid,
+			AccessLevel: &l,
+		})
+	}
+
+	if resp != nil && resp.StatusCode == 409 {
+		contextLogger.Debugf("user %d already is a member", uid)
+		return nil
+	}
+	journal(store.ActionCreate, o, t, err)
+	return err
+}
+
+// jiraGet does a get request against the jira api and decodes the response in v
+func jiraGet(jlc *jira.Client, url string, v interface{}) error {
+	req, err := jlc.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	_, err = jlc.Do(req, v)
+	return err
+}
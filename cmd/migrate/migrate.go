@@ -1,6 +1,10 @@
 package migrate
 
 import (
+	"io"
+	"os"
+
+	"github.com/schollz/progressbar"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -12,10 +16,62 @@ var migrateCMD = &cobra.Command{
 
 var contextLogger = log.WithFields(log.Fields{"Command": "Migrate"})
 
+// SetLogger has the package log to l instead of the standard logger, it returns the logger used until now
+func SetLogger(l *log.Logger) *log.Logger {
+	was := contextLogger.Logger
+	contextLogger = l.WithFields(log.Fields{"Command": "Migrate"})
+	return was
+}
+
+// out receives the progress the package reports to whoever is watching
+var out io.Writer = os.Stdout
+
+// SetOutput has the package report its progress to w instead of stdout, progress bars are left out then.
+// it returns the writer reported to until now
+func SetOutput(w io.Writer) io.Writer {
+	was := out
+	out = w
+	return was
+}
+
+// progress counts the steps of something that takes a while
+type progress interface {
+	Add(int) error
+}
+
+type noProgress struct{}
+
+func (noProgress) Add(int) error { return nil }
+
+// newProgress returns a progress bar of n steps when reporting to stdout, one drawing nothing otherwise
+func newProgress(n int) progress {
+	if out != os.Stdout {
+		return noProgress{}
+	}
+	return progressbar.New(n)
+}
+
+// AddRunFlags registers the flags steering how anything is written to gitlab on the given command,
+// they apply to every command that does
+func AddRunFlags(cmd *cobra.Command) {
+	addAuthorshipFlags(cmd)
+	addNotificationFlags(cmd)
+}
+
 //GetCommands grab and return commands in this package
 func GetCommands() *cobra.Command {
 
+	// flags selecting the jira issues apply to every migrate subcommand
+	addSelectionFlags(migrateCMD)
+	AddWriteBackFlags(migrateCMD)
+	addSourceFlags(migrateCMD)
+	addTargetFlags(migrateCMD)
+	addGiteaFlags(migrateCMD)
+
 	//collect the commands in the package
 	addProject()
+	addBatch()
+	addGroups()
+	addWriteBack()
 	return migrateCMD
 }
@@ -0,0 +1,120 @@
+package migrate
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/wianvos/pigmy/cmd/store"
+	utils "github.com/wianvos/pigmy/cmd/utils"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+var quietNotifications bool
+
+// addNotificationFlags registers the notification flags on the given command
+func addNotificationFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVar(&quietNotifications, "quiet-notifications", false, "disable the notifications of every user involved on the migrated project for the duration of the run (needs an admin token)")
+	viper.BindPFlag("quietNotifications", cmd.PersistentFlags().Lookup("quiet-notifications"))
+}
+
+// quietMembers silences the token owner and whoever already is a member of the project.
+// users pigmy adds are silenced by User.Create as soon as they are members
+func (p *Project) quietMembers() {
+	if !viper.GetBool("quietNotifications") {
+		return
+	}
+	glc := utils.GetGitlabClient()
+
+	if me, _, err := glc.Users.CurrentUser(); err == nil {
+		p.quiet(me.ID, me.Username)
+	} else {
+		contextLogger.WithError(err).Error("unable to retrieve the token owner")
+	}
+
+	o := &gitlab.ListProjectMembersOptions{ListOptions: gitlab.ListOptions{PerPage: 100, Page: 1}}
+	for {
+		ms, resp, err := glc.ProjectMembers.ListAllProjectMembers(p.Pid, o)
+		if err != nil {
+			contextLogger.WithError(err).Error("unable to list the project members")
+			return
+		}
+		for _, m := range ms {
+			p.quiet(m.ID, m.Username)
+		}
+		if resp.NextPage == 0 {
+			return
+		}
+		o.Page = resp.NextPage
+	}
+}
+
+// quiet disables the notifications of gitlab user uid for the project, recording what they were first.
+// the global level is left alone, it would silence the user on every other project too
+func (p *Project) quiet(uid int, un string) {
+	if !viper.GetBool("quietNotifications") {
+		return
+	}
+	contextLogger := contextLogger.WithField("user", un)
+	r := activeRun()
+	if r == nil {
+		contextLogger.Error("no run to record the notification settings in, leaving them alone")
+		return
+	}
+	glc := utils.GetGitlabClient()
+	dl := gitlab.DisabledNotificationLevel
+
+	// a project setting other than global wins over the global one
+	ns, _, err := glc.NotificationSettings.GetSettingsForProject(p.Pid, gitlab.WithSudo(uid))
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to read the notification settings")
+		return
+	}
+	if ns.Level == dl {
+		return
+	}
+
+	// record before changing, so even a crash right after can be undone
+	n := &store.Notification{Username: un, UserID: uid, ProjectID: p.Pid, Level: ns.Level.String()}
+	first, err := r.AddNotification(n)
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to record the notification settings, leaving them alone")
+		return
+	}
+	if !first {
+		return
+	}
+
+	o := &gitlab.NotificationSettingsOptions{Level: &dl}
+	if _, _, err := glc.NotificationSettings.UpdateSettingsForProject(p.Pid, o, gitlab.WithSudo(uid)); err != nil {
+		contextLogger.WithError(err).Error("unable to disable notifications")
+	}
+}
+
+// restoreNotifications puts back the notification settings run r changed
+func restoreNotifications(r *store.Run) {
+	glc := utils.GetGitlabClient()
+
+	for _, n := range r.Notifications {
+		if n.Restored {
+			continue
+		}
+		l := gitlab.NotificationLevelValue(0)
+		// unknown names come back as disabled, we don't want those
+		if err := l.UnmarshalJSON([]byte(`"` + n.Level + `"`)); err != nil || l.String() != n.Level {
+			contextLogger.Errorf("unknown notification level %s of %s", n.Level, n.Username)
+			continue
+		}
+
+		var err error
+		o := &gitlab.NotificationSettingsOptions{Level: &l}
+		if n.ProjectID == 0 {
+			_, _, err = glc.NotificationSettings.UpdateGlobalSettings(o, gitlab.WithSudo(n.UserID))
+		} else {
+			_, _, err = glc.NotificationSettings.UpdateSettingsForProject(n.ProjectID, o, gitlab.WithSudo(n.UserID))
+		}
+		if err != nil {
+			contextLogger.WithError(err).Errorf("unable to restore the notification settings of %s", n.Username)
+			continue
+		}
+		n.Restored = true
+	}
+}
@@ -1,31 +1,33 @@
 package migrate
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
 
-	"github.com/schollz/progressbar"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	jira "github.com/wianvos/go-jira"
+	"github.com/wianvos/pigmy/cmd/store"
 	utils "github.com/wianvos/pigmy/cmd/utils"
 	gitlab "github.com/xanzy/go-gitlab"
 )
 
-var projectName string
-
 var (
-	gitlabUsers = make(map[string]*gitlab.User)
+	gitlabUsers   = make(map[string]*gitlab.User)
+	gitlabUsersMu sync.Mutex
+	// newProject holds the creation settings passed as flags to migrate project
+	newProject ProjectConfig
 )
 
-var chunksize int
-
 const tmpPassword = "dummy12345"
 const retry = 3
 const retryTimeSeconds = 5
@@ -39,6 +41,12 @@ func addProject() {
 		Run:   runProject,
 	}
 
+	cmd.Flags().StringVar(&newProject.Namespace, "namespace", "", "full path of the gitlab group to create the project in")
+	cmd.Flags().StringVar(&newProject.Path, "path", "", "path of the gitlab project to create")
+	cmd.Flags().StringVar(&newProject.Visibility, "visibility", "", "visibility of the gitlab project to create: private, internal or public")
+	cmd.Flags().StringVar(&newProject.Description, "description", "", "description of the gitlab project to create")
+	cmd.Flags().StringVar(&newProject.GithubArchive, "github-archive", "", "github migration archive to take the issues of the named repository from instead of jira")
+
 	migrateCMD.AddCommand(cmd)
 
 }
@@ -52,9 +60,24 @@ func runProject(cmd *cobra.Command, args []string) {
 		os.Exit(2)
 	}
 
-	projectName = args[0]
+	c := newProject
+	c.Name, c.JQL, c.Project = args[0], jqlQuery, viper.GetString("gitlabProjectID")
+
+	d, err := configDefaults()
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to read the project section of the config")
+		fmt.Println("unable to read the project section of the config")
+		os.Exit(2)
+	}
+	c = c.merge(d)
+
+	contextLogger = contextLogger.WithFields(log.Fields{"Project": c.Name})
 
-	contextLogger = contextLogger.WithFields(log.Fields{"Project": projectName})
+	if _, err := BeginRun("migrate project " + c.Name); err != nil {
+		contextLogger.WithError(err).Error("unable to record the run")
+		fmt.Printf("unable to record the run: %s .. exiting\n", err)
+		os.Exit(2)
+	}
 
 	// to fully migrate a project we will need to migrate the following parts
 	// users
@@ -71,43 +94,80 @@ func runProject(cmd *cobra.Command, args []string) {
 	// migrate attachements ( you guessed it )
 
 	// first lets fetch the issues belonging to the project
-	p := fetchProject()
+	p, err := FetchProject(context.Background(), c)
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to retrieve project from jira")
+		fmt.Printf("unable to retrieve project from jira: %s .. exiting\n", err)
+		exit(2)
+	}
 
-	p.MigrateProject()
+	if err := p.MigrateProject(); err != nil {
+		contextLogger.WithError(err).Error("unable to migrate project")
+		fmt.Printf("unable to migrate project: %s .. exiting\n", err)
+		exit(2)
+	}
 
+	// point the jira issues to their new home
+	p.WriteBackIfEnabled()
+
+	EndRun()
 }
 
 //Project holds all the project goodies
 type Project struct {
-	Pid    int
-	Name   string
-	Issues Issues
-	Users  Users
+	Pid       int
+	Name      string
+	Config    ProjectConfig
+	Issues    Issues
+	Users     Users
+	Mapping   *store.Store
+	Source    Source
+	Target    Target
+	StartedAt time.Time
+	Migrated  int
+	Failed    int
+
+	// unfetched counts the issues the source failed to hand out, they fail the run as well
+	unfetched int
+
+	// access holds the gitlab access level of jira users, see accessLevel
+	access map[string]gitlab.AccessLevelValue
+	// ctx stops the migration between issues when it is done
+	ctx context.Context
 }
 
 // Issues holds everything we need to recreate the exact issue in gitlab
 type Issue struct {
 	CreatorID    string
 	JiraID       string
+	JiraKey      string
+	URL          string // where the issue lives when that isn't jira
 	Title        string
 	Description  string
 	Status       string
 	Assignee     string
 	AssigneeIDs  []int
 	Labels       []string
+	JiraLabels   []string
+	FixVersions  []string
 	CreatedAt    time.Time
+	UpdatedAt    time.Time
 	Comments     Comments
 	Attachements Attachements
 }
 
 type Comment struct {
+	JiraID    string
 	Body      string
 	CreatorID string
+	CreatedAt time.Time
 }
 
 type Attachement struct {
+	JiraID    string
 	FileName  string
 	CreatorID string
+	CreatedAt time.Time
 }
 
 type User struct {
@@ -123,24 +183,111 @@ type Comments []Comment
 type Attachements []Attachement
 type Issues []Issue
 
-func fetchProject() Project {
-
-	// procure a jira client object
-	jlc := utils.GetJiraClient()
+// FetchProject reads project c, its issues and users, from its source. it stops between issues
+// once ctx is done, the migration of the project does too
+func FetchProject(ctx context.Context, c ProjectConfig) (Project, error) {
 
 	// compose search query
 	// qc := jira.GetQueryOptions{Fields: "comment"}
 	// qa := jira.GetQueryOptions{Fields: "attachment"}
 
+	// load what we migrated before, an incremental run picks up where the last successful run left off.
+	// every kind of target has its own state
+	st, err := store.LoadTarget(targetKind(), c.Name)
+	if err != nil {
+		return Project{}, err
+	}
+	started := time.Now()
+
+	src, err := openSource(c)
+	if err != nil {
+		return Project{}, err
+	}
+
+	var since time.Time
+	if incremental && !st.LastRun.IsZero() {
+		contextLogger.Infof("incremental run, selecting issues updated since %s", st.LastRun)
+		since = st.LastRun
+	}
+
 	// get all issues related to the project
 
-	jql := fmt.Sprintf("project = %s", projectName)
+	fmt.Fprintln(out, "starting collection of Jira Issues")
+
+	issues, err := src.IssueIDs(c, since)
+	if err != nil {
+		contextLogger.Errorln(err)
+		fmt.Fprintln(out, "unable to retrieve issues")
+		return Project{}, err
+	}
+
+	contextLogger.Infof("found: %d issues", len(issues))
+	fmt.Fprintf(out, "found %d issues associated to project: %s \n ", len(issues), c.Name)
+
+	//initialize project
 
-	fmt.Println("starting collection of Jira Issues")
+	p := Project{Name: c.Name, Config: c, Mapping: st, StartedAt: started, Source: src, ctx: ctx}
+
+	//Feedback is everything .. let's start a progressbar
+	bar := newProgress(len(issues))
+
+	// and let's keep track of errors encountered
+	var ec int
+	ec = 0
+
+	// initialize the issue struct
+	var gIssues Issues
+	contextLogger.Infoln("starting the retrieval of the issues from JIRA")
+
+	// loop over issues and propegate them into the project object
+	for _, i := range issues {
+		if err := p.canceled(); err != nil {
+			return p, err
+		}
+		//init logger
+		contextLogger := contextLogger.WithFields(log.Fields{"Jira Issue": i})
+		//add one to the progressbar
+		bar.Add(1)
+
+		// get entire issue
+		t := time.Now()
+		gi, err := src.Issue(i)
+		if err != nil {
+			contextLogger.WithError(err).Errorln("unable to retrieve issue")
+			ec = ec + 1
+			o := &store.Object{Kind: store.ObjectIssue, JiraProject: c.Name, JiraID: i}
+			if targetKind() == store.TargetGitea {
+				o.Kind = store.GiteaPrefix + o.Kind
+			}
+			journal(store.ActionFetch, o, t, err)
+			continue
+		}
+		gi.Labels = c.labels(gi)
+
+		gIssues = append(gIssues, gi)
+	}
+
+	p.Issues = gIssues
+	p.unfetched = ec
+
+	p.PopulateUsers()
+
+	if ec != 0 {
+		contextLogger.Errorf("encountered %d errors while retrieving project from jira", ec)
+	}
+	return p, nil
+}
+
+// SearchIssues retrieves every jira issue matching jql, a chunk at a time
+func SearchIssues(jql string) (jira.Issues, error) {
+
+	// procure a jira client object
+	jlc := utils.GetJiraClient()
 
 	var index int
 	index = 0
 	var issues jira.Issues
+	var chunksize int
 
 	for {
 		if limit != 0 {
@@ -155,16 +302,16 @@ func fetchProject() Project {
 		//Retrieve the issues from the project
 		is, resp, err := jlc.Issue.Search(jql, &so)
 		if err != nil {
-			contextLogger.Errorln(err)
-			contextLogger.Errorln(resp.StatusCode)
-			fmt.Println("unable to retrieve issues")
-			os.Exit(2)
+			if resp != nil {
+				contextLogger.Errorln(resp.StatusCode)
+			}
+			return nil, err
 		}
 
 		contextLogger.Infof("retrieved: %d issues", len(is))
 
 		issues = append(issues, is...)
-		fmt.Printf("retrieved %d issues\n", len(issues))
+		fmt.Fprintf(out, "retrieved %d issues\n", len(issues))
 		//TODO: take this out before production
 
 		if len(is) < 1000 {
@@ -179,72 +326,60 @@ func fetchProject() Project {
 
 	}
 
-	contextLogger.Infof("found: %d issues", len(issues))
-	fmt.Printf("found %d issues associated to project: %s \n ", len(issues), projectName)
-
-	//initialize project
-
-	p := Project{Name: projectName}
-
-	//Feedback is everything .. let's start a progressbar
-	bar := progressbar.New(len(issues))
+	return issues, nil
+}
 
-	// and let's keep track of errors encountered
-	var ec int
-	ec = 0
+// FetchIssue retrieves a single jira issue in full and translates it into our issue model
+func FetchIssue(id string) (Issue, error) {
+	contextLogger := contextLogger.WithFields(log.Fields{"Jira Issue": id})
+	jlc := utils.GetJiraClient()
 
-	// initialize the issue struct
-	var gIssues Issues
-	contextLogger.Infoln("starting the retrieval of the issues from JIRA")
+	// get entire issue
+	// first get the right query options
 
-	// loop over issues and propegate them into the project object
-	for _, i := range issues {
-		//init logger
-		contextLogger := contextLogger.WithFields(log.Fields{"Jira Issue": i.ID})
-		//add one to the progressbar
-		bar.Add(1)
+	// ji, _, err := jlc.Issue.Get(i.ID, &jira.GetQueryOptions{Fields: "created"})
+	ji, _, err := jlc.Issue.Get(id, nil)
+	if err != nil {
+		return Issue{}, err
+	}
 
-		// get entire issue
-		// first get the right query options
+	contextLogger.Debug(ji)
 
-		// ji, _, err := jlc.Issue.Get(i.ID, &jira.GetQueryOptions{Fields: "created"})
-		ji, _, err := jlc.Issue.Get(i.ID, nil)
-		if err != nil {
-			contextLogger.Errorln("unable to retrieve issue")
-			ec = ec + 1
-		}
-
-		contextLogger.Debug(ji)
+	// log the found issue
+	contextLogger.Infoln("found issue")
+	gi := NewIssue(ji)
+	gi.Attachements = getAttachements(ji)
 
-		// log the found issue
-		contextLogger.Infoln("found issue")
-		gi := Issue{
-			CreatedAt:    time.Time(ji.Fields.Created),
-			CreatorID:    i.Fields.Creator.Name,
-			Title:        fmt.Sprintf("%s:%s", i.Key, i.Fields.Summary),
-			Description:  i.Fields.Description,
-			Labels:       []string{"To Do"},
-			Comments:     getComments(ji),
-			Attachements: getAttachements(ji),
-			JiraID:       ji.ID,
-			Status:       ji.Fields.Status.Name,
-			Assignee:     ji.Fields.Assignee.Name,
-		}
-		if gi.CreatorID == "admin" {
-			gi.CreatorID = "root"
-		}
+	return gi, nil
+}
 
-		gIssues = append(gIssues, gi)
+// NewIssue translates a jira issue into our issue model. attachements are left out, they need a download
+func NewIssue(ji *jira.Issue) Issue {
+	gi := Issue{
+		CreatedAt:   time.Time(ji.Fields.Created),
+		UpdatedAt:   time.Time(ji.Fields.Updated),
+		CreatorID:   ji.Fields.Creator.Name,
+		Title:       fmt.Sprintf("%s:%s", ji.Key, ji.Fields.Summary),
+		Description: ji.Fields.Description,
+		Labels:      []string{"To Do"},
+		JiraLabels:  ji.Fields.Labels,
+		Comments:    getComments(ji),
+		JiraID:      ji.ID,
+		JiraKey:     ji.Key,
+		Status:      ji.Fields.Status.Name,
 	}
-
-	p.Issues = gIssues
-
-	p.PopulateUsers()
-
-	if ec != 0 {
-		contextLogger.Errorf("encountered %d errors while retrieving project from jira", ec)
+	for _, v := range ji.Fields.FixVersions {
+		gi.FixVersions = append(gi.FixVersions, v.Name)
 	}
-	return p
+	// unassigned issues come without an assignee
+	if ji.Fields.Assignee != nil {
+		gi.Assignee = ji.Fields.Assignee.Name
+	}
+	if gi.CreatorID == "admin" {
+		gi.CreatorID = "root"
+	}
+
+	return gi
 }
 
 func getComments(ji *jira.Issue) Comments {
@@ -256,8 +391,10 @@ func getComments(ji *jira.Issue) Comments {
 			contextLogger.WithFields(log.Fields{"JiraComment": co.ID}).Info("attempting to create")
 
 			jn := Comment{
+				JiraID:    co.ID,
 				Body:      co.Body,
 				CreatorID: co.Author.Name,
+				CreatedAt: jiraTime(co.Created),
 			}
 
 			c = append(c, jn)
@@ -310,8 +447,10 @@ func getAttachements(ji *jira.Issue) Attachements {
 			contextLogger.WithFields(log.Fields{"JiraAttachement": ao.ID}).Infof("file saved to disk: %s", tf)
 
 			ja := Attachement{
+				JiraID:    ao.ID,
 				CreatorID: ao.Author.Name,
 				FileName:  tf,
+				CreatedAt: jiraTime(ao.Created),
 			}
 
 			a = append(a, ja)
@@ -327,15 +466,15 @@ func (p *Project) PopulateUsers() {
 
 	var users Users
 
-	fmt.Println("\ngetting all user id's associated with this project")
-	b := progressbar.New(len(p.Issues))
+	fmt.Fprintln(out, "\ngetting all user id's associated with this project")
+	b := newProgress(len(p.Issues))
 
 	for _, i := range p.Issues {
 		if i.CreatorID == "root" {
 			contextLogger.Debugln("found root while searching for user")
 		}
 		if !users.containsUser(i.CreatorID) {
-			u, err := jiraGetUser(i.CreatorID)
+			u, err := p.source().User(i.CreatorID)
 			if err == nil {
 				users = append(users, u)
 			}
@@ -343,7 +482,7 @@ func (p *Project) PopulateUsers() {
 
 		for _, c := range i.Comments {
 			if !users.containsUser(c.CreatorID) {
-				u, err := jiraGetUser(c.CreatorID)
+				u, err := p.source().User(c.CreatorID)
 				if err == nil {
 					users = append(users, u)
 				}
@@ -351,7 +490,7 @@ func (p *Project) PopulateUsers() {
 		}
 		for _, a := range i.Attachements {
 			if !users.containsUser(a.CreatorID) {
-				u, err := jiraGetUser(a.CreatorID)
+				u, err := p.source().User(a.CreatorID)
 				if err == nil {
 					users = append(users, u)
 				}
@@ -361,7 +500,7 @@ func (p *Project) PopulateUsers() {
 		b.Add(1)
 
 	}
-	fmt.Println("\n")
+	fmt.Fprintln(out)
 
 	contextLogger.Info("found the following users")
 	for _, u := range users {
@@ -413,63 +552,99 @@ func jiraGetUser(n string) (User, error) {
 	return u, nil
 }
 
-func (p *Project) MigrateProject() {
+// MigrateProject loads the project into its target, gitlab unless configured otherwise
+func (p *Project) MigrateProject() error {
+	if p.Target == nil {
+		t, err := openTarget()
+		if err != nil {
+			return err
+		}
+		p.Target = t
+	}
+	if err := p.Target.Migrate(p); err != nil {
+		return err
+	}
+	return p.canceled()
+}
+
+// canceled returns the error of the context of the project once it is done
+func (p *Project) canceled() error {
+	if p.ctx == nil {
+		return nil
+	}
+	return p.ctx.Err()
+}
 
-	glc := utils.GetGitlabClient()
+// Migrate creates the project in gitlab when needed, then its users and issues
+func (gitlabTarget) Migrate(p *Project) error {
 
-	contextLogger = contextLogger.WithField("project", p.Name)
-	fmt.Println("starting project migration")
+	contextLogger := contextLogger.WithField("project", p.Name)
+	fmt.Fprintln(out, "starting project migration")
+	// the project ids of other targets mean nothing to gitlab, or worse, something else
+	if p.Mapping.Target != store.TargetGitlab {
+		return fmt.Errorf("the migration state of %s belongs to a %s target", p.Name, p.Mapping.Target)
+	}
+	if err := loadAuthorship(); err != nil {
+		contextLogger.WithError(err).Error("unable to set up authorship")
+		return err
+	}
 	// does the project exist in gitlab ??
-	lpo := gitlab.ListProjectsOptions{Search: &p.Name}
-	pl, _, err := glc.Projects.ListProjects(&lpo, nil)
+	gp, err := p.findProject()
 	if err != nil {
-		contextLogger.Errorf("unable to query gitlab for projects")
+		contextLogger.WithError(err).Errorf("unable to determine the gitlab project")
+		return err
 	}
 
-	vv := gitlab.PublicVisibility
-	if len(pl) == 0 {
+	if gp == nil {
 		contextLogger.Debugf("project needs to be created")
-		_, _, err := glc.Projects.CreateProject(&gitlab.CreateProjectOptions{Name: &p.Name, Visibility: &vv})
+		gp, err = p.createProject()
 		if err != nil {
 			contextLogger.Errorf("unable to create project in Gitlab")
 			contextLogger.Error(err)
-			fmt.Println("unable to create project ... this is not good... duh .. exiting")
-			os.Exit(99)
+			fmt.Fprintln(out, "unable to create project ... this is not good... duh .. exiting")
+			return err
 		}
-	}
-	if len(pl) > 1 {
-		contextLogger.Debugf("multiple projects found .. it is not wise to continue")
-		fmt.Println("multiple projects found .. exiting.. we don't do rando stuff")
-		os.Exit(1)
+	} else {
+		contextLogger.Infof("project found: %s", gp.PathWithNamespace)
 	}
 
-	if len(pl) == 1 {
-		contextLogger.Infof("project found")
-	}
+	p.Pid = gp.ID
+
+	// other projects referencing our issues need to know where they live
+	p.Mapping.GitlabPID = p.Pid
+	p.Mapping.GitlabPath = gp.PathWithNamespace
 
-	// now lets retrieve the project id .. we need it further down the line
-	p.getPID()
+	p.quietMembers()
 
 	// if so create the users first
 
-	err = p.Users.Create(p.Pid)
+	err = p.Users.Create(p)
 	if err != nil {
 		contextLogger.Error("unable to migrate users.. ")
-		fmt.Println("unable to migrate users .. exiting")
-		os.Exit(2)
+		fmt.Fprintln(out, "unable to migrate users .. exiting")
+		return err
 	}
 
 	// now migrate the issues with notes and attachements
-
+	// gitlab creates the labels the issues use on the fly, what wasn't there before is ours
+	labels := projectLabels(p.Pid)
 	p.MigrateIssues()
+	if labels != nil {
+		for _, l := range projectLabels(p.Pid) {
+			if labels[l.Name] == nil {
+				journal(store.ActionCreate, &store.Object{Kind: store.ObjectLabel, ProjectID: p.Pid, ID: l.ID, Name: l.Name, JiraProject: p.Name}, time.Now(), nil)
+			}
+		}
+	}
+	return nil
 }
 
 //Create creates the collection of users in gitlab
-func (u *Users) Create(p int) error {
+func (u *Users) Create(p *Project) error {
 	contextLogger.Infoln("creating users")
 
-	fmt.Println("migrating users")
-	bar := progressbar.New(len(*u))
+	fmt.Fprintln(out, "migrating users")
+	bar := newProgress(len(*u))
 
 	for _, user := range *u {
 		if user.Username != "admin" && user.Username != "root" {
@@ -480,62 +655,48 @@ func (u *Users) Create(p int) error {
 		}
 		bar.Add(1)
 	}
-	fmt.Printf("\n")
+	fmt.Fprintf(out, "\n")
 	return nil
 }
 
 //Create creates a user in gitlab if it does nog exist
-func (u *User) Create(p int) error {
+func (u *User) Create(p *Project) error {
 	// setup the context logger
-	contextLogger = contextLogger.WithField("user", u.Username)
-
-	// retrieve gilab client
-	glc := utils.GetGitlabClient()
-
-	//setup variables
-	var cu *gitlab.User
-	var err error
+	contextLogger := contextLogger.WithField("user", u.Username)
 
 	// if the username is empty ... where dealing with root .. and root don't need no attention so skip
 	if u.Username != "" {
 
-		// try to get the user from gitlab
-		cu = gitlabUserGet(u.Username)
-
-		// if the returned user object is nil .. it means the user does not exist in gitlab so where creating it ..
-		if cu == nil {
-			// set a tmp password .. (constant)
-			tp := tmpPassword
-			a := true
-			// set the useroptions
-			gcuo := gitlab.CreateUserOptions{
-				Email:    &u.Email,
-				Username: &u.Username,
-				Name:     &u.Name,
-				Password: &tp,
-				Admin:    &a,
+		var cu *gitlab.User
+		if authorMode == authorshipHeader {
+			// header mode doesn't need an admin token, users are looked up but never created.
+			// the author header names whoever has no gitlab account
+			if cu = gitlabUserGet(u.Username); cu == nil {
+				contextLogger.Infof("user not in gitlab, left to the author header")
+				return nil
 			}
-
-			contextLogger.Debug("starting creation")
-			// create the user
-			cu, _, err = glc.Users.CreateUser(&gcuo, nil)
-			//handle error
-			if err != nil {
-				contextLogger.Error(err)
-				contextLogger.Errorln("unable to create")
+		} else {
+			var err error
+			if cu, err = u.ensure(); err != nil {
 				return err
 			}
-		} else {
-			contextLogger.Infof("user already exists")
 		}
 
-		//add the user to our project
-		err = gitlabAddUserToProjectAsMaster(cu.ID, p)
+		//add the user to our project with the access its jira roles warrant
+		l, err := p.accessLevel(u.Username)
+		if err == nil {
+			err = p.addMember(cu.ID, l)
+		}
+
+		// silence the user before the issues go in. gitlab only shows the notification settings of
+		// private and internal projects to members, so not before the membership
+		p.quiet(cu.ID, cu.Username)
 
+		// a missing membership is no reason to stop the migration, the issues go in without it
 		if err != nil {
 			contextLogger.Error(err)
 			contextLogger.Errorf("unable to add user to project")
-			return err
+			return nil
 		}
 
 		contextLogger.Infof("user added to project")
@@ -544,10 +705,59 @@ func (u *User) Create(p int) error {
 	return nil
 }
 
+// ensure returns the gitlab user, creating it when it does not exist
+func (u *User) ensure() (*gitlab.User, error) {
+	contextLogger := contextLogger.WithField("user", u.Username)
+
+	// retrieve gilab client
+	glc := utils.GetGitlabClient()
+
+	// try to get the user from gitlab
+	cu := gitlabUserGet(u.Username)
+	if cu != nil {
+		contextLogger.Infof("user already exists")
+		return cu, nil
+	}
+
+	// the returned user object is nil .. it means the user does not exist in gitlab so where creating it ..
+	// set a tmp password .. (constant)
+	// users are created without admin rights, what they may do comes from their memberships
+	tp := tmpPassword
+	// set the useroptions
+	gcuo := gitlab.CreateUserOptions{
+		Email:    &u.Email,
+		Username: &u.Username,
+		Name:     &u.Name,
+		Password: &tp,
+	}
+
+	contextLogger.Debug("starting creation")
+	// create the user
+	t := time.Now()
+	cu, _, err := glc.Users.CreateUser(&gcuo, nil)
+	// another project migrating alongside us might just have created it
+	if err != nil {
+		if eu := gitlabUserGet(u.Username); eu != nil {
+			return eu, nil
+		}
+	}
+	//handle error
+	if err != nil {
+		journal(store.ActionCreate, &store.Object{Kind: store.ObjectUser, Name: u.Username}, t, err)
+		contextLogger.Error(err)
+		contextLogger.Errorln("unable to create")
+		return nil, err
+	}
+	journal(store.ActionCreate, &store.Object{Kind: store.ObjectUser, ID: cu.ID, Name: cu.Username}, t, nil)
+	return cu, nil
+}
+
 func gitlabUserGet(us string) *gitlab.User {
 
 	// lets see if we searched for this user before
 	// to do this we store every user we find in gitlab in this map and search that before we go to the actual system ..
+	gitlabUsersMu.Lock()
+	defer gitlabUsersMu.Unlock()
 	for n, u := range gitlabUsers {
 		if n == us {
 			return u
@@ -560,8 +770,6 @@ func gitlabUserGet(us string) *gitlab.User {
 		Search: &us,
 	}
 
-	contextLogger = contextLogger.WithField("username", us)
-
 	ul, _, err := glc.Users.ListUsers(&so, nil)
 	if err != nil {
 		log.Errorf("unable to search for user %s", us)
@@ -589,51 +797,72 @@ func gitlabUserGet(us string) *gitlab.User {
 
 }
 
-func gitlabAddUserToProjectAsMaster(u, p int) error {
-
+// findProject determines the gitlab project to migrate to, nil when it needs to be created.
+// an explicitly configured id or path wins, then the project a previous run migrated to,
+// and only then a project with the target name. more than one candidate is an error, we don't guess
+func (p *Project) findProject() (*gitlab.Project, error) {
 	glc := utils.GetGitlabClient()
 
-	perm := gitlab.MasterPermissions
-
-	_, resp, err := glc.ProjectMembers.AddProjectMember(p, &gitlab.AddProjectMemberOptions{
-		UserID:      &u,
-		AccessLevel: &perm,
-	})
-
-	if resp.StatusCode == 409 {
-		contextLogger.Debug(err)
-		contextLogger.Debugf("unable to add user to project")
-		return nil
-
+	if p.Config.Project != "" {
+		var pid interface{} = p.Config.Project
+		if id, err := strconv.Atoi(p.Config.Project); err == nil {
+			pid = id
+		}
+		gp, resp, err := glc.Projects.GetProject(pid, nil)
+		if resp != nil && resp.StatusCode == 404 {
+			return nil, fmt.Errorf("gitlab project %s not found", p.Config.Project)
+		}
+		return gp, err
 	}
 
-	if err != nil {
-		contextLogger.Errorln(err)
-		contextLogger.Errorf("unable to add user to project as Master")
-		return errors.New("unable to add user to project")
+	if p.Mapping.GitlabPID != 0 {
+		gp, resp, err := glc.Projects.GetProject(p.Mapping.GitlabPID, nil)
+		if err == nil {
+			return gp, nil
+		}
+		if resp == nil || resp.StatusCode != 404 {
+			return nil, err
+		}
+		contextLogger.Warnf("gitlab project %d from a previous run no longer exists", p.Mapping.GitlabPID)
 	}
 
-	return nil
-
-}
+	tn := p.Config.target()
+	o := &gitlab.ListProjectsOptions{Search: &tn, ListOptions: gitlab.ListOptions{PerPage: 100, Page: 1}}
 
-func (p *Project) getPID() {
-	glc := utils.GetGitlabClient()
-
-	pl, _, err := glc.Projects.ListProjects(nil, nil)
-	if err != nil {
-		contextLogger.Error(err)
-		contextLogger.Errorf("unable to retrieve project ID")
-		os.Exit(1)
+	// the search also matches partial names, only exact matches count
+	var candidates []*gitlab.Project
+	for {
+		pl, resp, err := glc.Projects.ListProjects(o)
+		if err != nil {
+			return nil, err
+		}
+		for _, gp := range pl {
+			if gp.Name != tn && gp.Path != tn {
+				continue
+			}
+			if p.Config.Namespace != "" && gp.Namespace != nil && gp.Namespace.FullPath != p.Config.Namespace {
+				continue
+			}
+			candidates = append(candidates, gp)
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		o.Page = resp.NextPage
 	}
 
-	for _, pj := range pl {
-		if pj.Name == p.Name {
-			p.Pid = pj.ID
-		}
+	switch len(candidates) {
+	case 0:
+		return nil, nil
+	case 1:
+		return candidates[0], nil
 	}
-	contextLogger.Infof("retrieved PID %d for project", p.Pid)
 
+	var paths []string
+	for _, gp := range candidates {
+		paths = append(paths, fmt.Sprintf("%s (%d)", gp.PathWithNamespace, gp.ID))
+	}
+	return nil, fmt.Errorf("%d gitlab projects named %s: %s. pick one with --gitlabProjectID", len(candidates), tn, strings.Join(paths, ", "))
 }
 
 //Issue stuff below
@@ -642,27 +871,49 @@ func (p *Project) getPID() {
 func (p *Project) MigrateIssues() {
 
 	contextLogger.Info("starting issue creation")
-	fmt.Println("Migrating Issues")
+	fmt.Fprintln(out, "Migrating Issues")
 	// x := 1
-	bar := progressbar.New(len(p.Issues))
+	bar := newProgress(len(p.Issues))
 	s := 0
-	e := 0
+	// issues that could not be fetched failed too, the next incremental run has to select them again
+	e := p.unfetched
 
 	for _, i := range p.Issues {
+		if p.canceled() != nil {
+			break
+		}
 
 		bar.Add(1)
+		// the run journal tells what went wrong, and where
 		err := i.Create(p)
 		if err != nil {
 			e = e + 1
-			f := utils.GetTmpDirFileName(i.JiraID)
-			contextLogger.Errorf("dumping jira record %s to file %s for further investigation", i.JiraID, f)
-			utils.WriteToFile(utils.RenderJSON(i), f)
+			contextLogger.WithError(err).Errorf("unable to migrate jira issue %s", i.JiraKey)
 		} else {
 			s = s + 1
 		}
 
+		// persist the mapping after every issue so a crashed run can be picked up again
+		if err := p.Mapping.Save(); err != nil {
+			contextLogger.WithError(err).Error("unable to save migration state")
+		}
+	}
+
+	// references to issues created later in the run can be resolved now
+	p.ResolveForwardReferences()
+
+	// only a clean run moves the high-water mark, otherwise the next incremental run would miss the failed issues
+	p.Mapping.GitlabPID = p.Pid
+	if e == 0 && p.canceled() == nil {
+		p.Mapping.LastRun = p.StartedAt
+	}
+	if err := p.Mapping.Save(); err != nil {
+		contextLogger.WithError(err).Error("unable to save migration state")
 	}
-	fmt.Printf("project migrated. %d issues migrated succesfully. %d errors encountered", s, e)
+
+	p.Migrated = s
+	p.Failed = e
+	fmt.Fprintf(out, "project migrated. %d issues migrated succesfully. %d errors encountered", s, e)
 
 }
 
@@ -670,12 +921,20 @@ func (p *Project) MigrateIssues() {
 func (i *Issue) Create(p *Project) error {
 	contextLogger := log.WithFields(log.Fields{"JiraIssueID": i.JiraID})
 
+	// migrated before ? then bring the existing gitlab issue up to date instead
+	if m := p.Mapping.Issue(i.JiraID); m != nil {
+		if m.WriteBack != nil && !i.UpdatedAt.After(m.WriteBack.Updated) {
+			contextLogger.Info("only the write back changed the jira issue, skipping it")
+			journal(store.ActionSkip, i.object(p, m.IID), time.Now(), nil)
+			return nil
+		}
+		return i.Update(p, m)
+	}
+
 	contextLogger.Infof("start migration")
 
 	glc := utils.GetGitlabClient()
 
-	var assigneeIDs []int
-	var ai []int
 	var o *gitlab.Issue
 	var err error
 	var resp *gitlab.Response
@@ -683,39 +942,36 @@ func (i *Issue) Create(p *Project) error {
 	// see if the issue already exists in gitlab
 	si, _, err := glc.Issues.ListProjectIssues(p.Pid, &gitlab.ListProjectIssuesOptions{Search: &i.Title})
 	if err != nil {
-		fmt.Println("lp: err not nil")
+		fmt.Fprintln(out, "lp: err not nil")
 	}
 	if len(si) != 0 {
 		contextLogger.WithField("issue title", si[0].Title).Infoln("issue found skipping migration")
+		// remember it, so the next incremental run updates it instead of searching for it again
+		p.Mapping.SetIssue(i.existingMapping(si[0]))
+		journal(store.ActionSkip, i.object(p, si[0].IID), time.Now(), nil)
 		return nil
 	}
 
 	// compose the list of assignee's
-	if au := gitlabUserGet(i.Assignee); au != nil {
-		ai = append(ai, au.ID)
-		assigneeIDs = ai
-	} else {
-		ai = append(ai, 1)
-		assigneeIDs = ai
-	}
+	assigneeIDs := i.assigneeIDs()
 
-	if au := gitlabUserGet(i.CreatorID); au == nil {
-		i.CreatorID = "1"
-	}
+	// post as the creator, or name the creator in the description
+	ac, ao := author(i.CreatorID)
 
 	rc := 0
+	t := time.Now()
 	// dropping the note into gitlab .. like it's hot
 	for {
-		d := translateText(i.Description)
-		o, resp, err = glc.Issues.CreateIssue(
+		d := p.issueBody(i)
+		o, resp, err = ac.Issues.CreateIssue(
 			p.Pid,
 			&gitlab.CreateIssueOptions{
 				Title:       &i.Title,
 				Description: &d,
 				AssigneeIDs: assigneeIDs,
-				Labels:      []string{"To Do"},
+				Labels:      i.Labels,
 				CreatedAt:   &i.CreatedAt,
-			}, gitlab.WithSudo(i.CreatorID))
+			}, ao...)
 
 		if err != nil {
 			contextLogger.WithError(err).Errorf("unable to create issue in gitlab, will retry in %d seconds", retryTimeSeconds)
@@ -724,8 +980,8 @@ func (i *Issue) Create(p *Project) error {
 			rc = rc + 1
 			if rc == retry {
 				contextLogger.Error("unable to complete request using retry window .. moving on to the next issue")
+				journal(store.ActionCreate, i.object(p, 0), t, err)
 				return err
-				break
 			}
 		} else {
 			contextLogger.Debug(o)
@@ -738,21 +994,22 @@ func (i *Issue) Create(p *Project) error {
 		time.Sleep(retryTimeSeconds * time.Second)
 	}
 
+	// record the new issue straight away, whatever fails below can be appended by the next run
+	journal(store.ActionCreate, i.object(p, o.IID), t, nil)
+	m := &store.Issue{JiraID: i.JiraID, JiraKey: i.JiraKey, IID: o.IID, WebURL: o.WebURL}
+	m.PendingRefs = p.hasPendingReferences(i)
+	p.Mapping.SetIssue(m)
+
 	// handeling the comments
 	for x, c := range i.Comments {
-		b := translateText(c.Body)
 		contextLogger := contextLogger.WithField("comment", x)
-		in, _, err := glc.Notes.CreateIssueNote(
-			p.Pid,
-			o.IID,
-			&gitlab.CreateIssueNoteOptions{Body: &b},
-			gitlab.WithSudo(i.CreatorID))
-
+		in, err := i.createComment(p, o.IID, c)
 		if err != nil {
 			contextLogger.Error(err)
 			contextLogger.Errorln("unable to create Comment")
 			return err
 		}
+		m.Comments[c.JiraID] = in.ID
 		contextLogger.Debug(in)
 		contextLogger.Infoln("comment created")
 
@@ -761,27 +1018,12 @@ func (i *Issue) Create(p *Project) error {
 	//attachements... don't get too attached .. that's what my momma used to say :-)
 	for _, a := range i.Attachements {
 		contextLogger := contextLogger.WithField("Filename", a.FileName)
-		aresp, _, err := glc.Projects.UploadFile(p.Pid, a.FileName, nil, nil)
-		if err != nil {
-			contextLogger.Error(err)
-			break
-		}
-
-		contextLogger.Info("file uploaded")
-		gin := gitlab.CreateIssueNoteOptions{Body: &aresp.Markdown}
-		// create a note with the attachement file .
-		_, _, err = glc.Notes.CreateIssueNote(p.Pid, o.IID, &gin)
-
+		in, err := i.createAttachement(p, o.IID, a)
 		if err != nil {
 			contextLogger.Error(err)
 			break
 		}
-
-		// lets clean-up after ourselves
-		err = os.Remove(a.FileName)
-		if err != nil {
-			contextLogger.WithError(err).Errorln("unable to remove attachement file")
-		}
+		m.Attachments[a.JiraID] = in.ID
 		contextLogger.Info("created")
 	}
 	// status closed ?? np ... we got ya
@@ -801,6 +1043,201 @@ func (i *Issue) Create(p *Project) error {
 	return nil
 }
 
+// Update brings an already migrated gitlab issue in line with its jira counterpart.
+// title, description, labels, state and assignee are overwritten, comments and attachements not seen before are appended
+func (i *Issue) Update(p *Project, m *store.Issue) error {
+	contextLogger := log.WithFields(log.Fields{"JiraIssueID": i.JiraID, "IID": m.IID})
+
+	contextLogger.Infof("start update")
+
+	glc := utils.GetGitlabClient()
+
+	// make sure the comment and attachement maps are initialized
+	p.Mapping.SetIssue(m)
+
+	d := p.issueBody(i)
+	se := i.stateEvent()
+	t := time.Now()
+	_, _, err := glc.Issues.UpdateIssue(p.Pid, m.IID, &gitlab.UpdateIssueOptions{
+		Title:       &i.Title,
+		Description: &d,
+		AssigneeIDs: i.assigneeIDs(),
+		Labels:      i.Labels,
+		StateEvent:  &se,
+	})
+	journal(store.ActionUpdate, i.object(p, m.IID), t, err)
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to update issue in gitlab")
+		return err
+	}
+	contextLogger.Infof("issue updated")
+
+	for _, c := range i.Comments {
+		if _, ok := m.Comments[c.JiraID]; ok {
+			continue
+		}
+		contextLogger := contextLogger.WithField("JiraComment", c.JiraID)
+		in, err := i.createComment(p, m.IID, c)
+		if err != nil {
+			contextLogger.WithError(err).Errorln("unable to create Comment")
+			return err
+		}
+		m.Comments[c.JiraID] = in.ID
+		contextLogger.Infoln("comment appended")
+	}
+
+	for _, a := range i.Attachements {
+		contextLogger := contextLogger.WithField("Filename", a.FileName)
+		if _, ok := m.Attachments[a.JiraID]; ok {
+			// we downloaded it again for nothing .. clean it up
+			os.Remove(a.FileName)
+			continue
+		}
+		in, err := i.createAttachement(p, m.IID, a)
+		if err != nil {
+			contextLogger.Error(err)
+			break
+		}
+		m.Attachments[a.JiraID] = in.ID
+		contextLogger.Info("attachement appended")
+	}
+
+	m.PendingRefs = p.hasPendingReferences(i)
+	p.Mapping.SetIssue(m)
+	return nil
+}
+
+// createComment adds a jira comment as a note to gitlab issue iid
+func (i *Issue) createComment(p *Project, iid int, c Comment) (*gitlab.Note, error) {
+	// the comment's own author writes it, the issue creator when gitlab doesn't know the author
+	u := c.CreatorID
+	if gitlabUserGet(u) == nil {
+		u = i.CreatorID
+	}
+	ac, ao := author(u)
+
+	t := time.Now()
+	b := p.commentBody(i, c)
+	in, _, err := ac.Notes.CreateIssueNote(
+		p.Pid,
+		iid,
+		&gitlab.CreateIssueNoteOptions{Body: &b},
+		ao...)
+
+	o := i.object(p, iid)
+	o.Kind, o.JiraComment, o.Author, o.Unmapped = store.ObjectNote, c.JiraID, c.CreatorID, nil
+	if err == nil {
+		o.ID = in.ID
+	}
+	journal(store.ActionCreate, o, t, err)
+
+	return in, err
+}
+
+// createAttachement uploads an attachement file and links it from a note on gitlab issue iid
+func (i *Issue) createAttachement(p *Project, iid int, a Attachement) (*gitlab.Note, error) {
+	contextLogger := contextLogger.WithField("Filename", a.FileName)
+	glc := utils.GetGitlabClient()
+
+	o := i.object(p, iid)
+	o.Kind, o.JiraAttachment, o.Author, o.Unmapped = store.ObjectNote, a.JiraID, a.CreatorID, nil
+	t := time.Now()
+
+	aresp, _, err := glc.Projects.UploadFile(p.Pid, a.FileName, nil, nil)
+	if err != nil {
+		journal(store.ActionCreate, o, t, err)
+		return nil, err
+	}
+
+	contextLogger.Info("file uploaded")
+	b := attribute(aresp.Markdown, a.CreatorID, a.CreatedAt, i)
+	gin := gitlab.CreateIssueNoteOptions{Body: &b}
+	// create a note with the attachement file .
+	in, _, err := glc.Notes.CreateIssueNote(p.Pid, iid, &gin)
+	if err != nil {
+		journal(store.ActionCreate, o, t, err)
+		return nil, err
+	}
+	o.ID = in.ID
+	journal(store.ActionCreate, o, t, nil)
+
+	// lets clean-up after ourselves
+	err = os.Remove(a.FileName)
+	if err != nil {
+		contextLogger.WithError(err).Errorln("unable to remove attachement file")
+	}
+
+	return in, nil
+}
+
+// object describes the issue as gitlab issue iid for the run journal
+func (i *Issue) object(p *Project, iid int) *store.Object {
+	return &store.Object{
+		Kind:        store.ObjectIssue,
+		ProjectID:   p.Pid,
+		IssueIID:    iid,
+		JiraProject: p.Name,
+		JiraID:      i.JiraID,
+		JiraKey:     i.JiraKey,
+		Author:      i.CreatorID,
+		Unmapped:    i.unmapped(p),
+	}
+}
+
+// unmapped lists the jira fields of the issue gitlab ends up without
+func (i *Issue) unmapped(p *Project) []string {
+	var u []string
+	if gitlabUserGet(i.CreatorID) == nil {
+		u = append(u, "author")
+	}
+	if i.Assignee != "" && gitlabUserGet(i.Assignee) == nil {
+		u = append(u, "assignee")
+	}
+	if _, ok := p.Config.Statuses[i.Status]; len(p.Config.Statuses) != 0 && !ok {
+		u = append(u, "status")
+	}
+	if len(i.FixVersions) != 0 {
+		u = append(u, "fixVersions")
+	}
+	return u
+}
+
+// assigneeIDs resolves the jira assignee to a gitlab user id, falling back to root
+func (i *Issue) assigneeIDs() []int {
+	if au := gitlabUserGet(i.Assignee); au != nil {
+		return []int{au.ID}
+	}
+	return []int{1}
+}
+
+// stateEvent returns the gitlab state event matching the jira status
+func (i *Issue) stateEvent() string {
+	if i.Status != "Open" {
+		return "close"
+	}
+	return "reopen"
+}
+
+// existingMapping builds the mapping for an issue that was found in gitlab without being recorded in the store.
+// its comments and attachements are assumed to be there already
+func (i *Issue) existingMapping(gi *gitlab.Issue) *store.Issue {
+	m := &store.Issue{
+		JiraID:      i.JiraID,
+		JiraKey:     i.JiraKey,
+		IID:         gi.IID,
+		WebURL:      gi.WebURL,
+		Comments:    make(map[string]int),
+		Attachments: make(map[string]int),
+	}
+	for _, c := range i.Comments {
+		m.Comments[c.JiraID] = 0
+	}
+	for _, a := range i.Attachements {
+		m.Attachments[a.JiraID] = 0
+	}
+	return m
+}
+
 func translateText(t string) string {
 	translations := map[string]string{
 		"[noformat]":  "```",
@@ -0,0 +1,117 @@
+package migrate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// selection flags narrowing down which jira issues get migrated
+var (
+	jqlQuery     string
+	updatedSince string
+	issueTypes   []string
+	statuses     []string
+	issueKeys    []string
+	incremental  bool
+)
+
+// jqlTimeFormat is the date time layout jira accepts in jql date clauses
+const jqlTimeFormat = "2006/01/02 15:04"
+
+// addSelectionFlags registers the issue selection flags on the given command
+func addSelectionFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&jqlQuery, "jql", "", "jira JQL query selecting the issues to migrate (defaults to the entire project)")
+	cmd.PersistentFlags().StringVar(&updatedSince, "updated-since", "", "only migrate issues updated on or after this date (yyyy-mm-dd or relative like -2w)")
+	cmd.PersistentFlags().StringSliceVar(&issueTypes, "issue-type", nil, "only migrate issues of these types (comma separated)")
+	cmd.PersistentFlags().StringSliceVar(&statuses, "status", nil, "only migrate issues in these statuses (comma separated)")
+	cmd.PersistentFlags().StringSliceVar(&issueKeys, "keys", nil, "only migrate these issue keys (comma separated)")
+	cmd.PersistentFlags().BoolVar(&incremental, "incremental", false, "only migrate issues updated since the last successful run and update the ones migrated before")
+}
+
+// SetIncremental switches incremental runs on or off, the way the incremental flag does. it returns
+// whether they were on
+func SetIncremental(b bool) bool {
+	was := incremental
+	incremental = b
+	return was
+}
+
+// buildJQL composes the jql used to select the issues for project c.
+// an explicit jql replaces the default project clause, the convenience flags and any extra clauses are and-ed onto it
+func buildJQL(c ProjectConfig, extra ...string) string {
+	base, order := splitOrderBy(c.JQL)
+
+	var clauses []string
+	if base != "" {
+		clauses = append(clauses, fmt.Sprintf("(%s)", base))
+	} else {
+		clauses = append(clauses, fmt.Sprintf("project = %s", quoteJQL(c.Name)))
+	}
+
+	if updatedSince != "" {
+		clauses = append(clauses, fmt.Sprintf("updated >= %s", quoteJQL(updatedSince)))
+	}
+	// a list of nothing but blanks selects nothing, jql doesn't even accept it
+	for _, in := range []struct {
+		field  string
+		values []string
+	}{{"issuetype", issueTypes}, {"status", statuses}, {"key", issueKeys}} {
+		if l := quoteJQLList(in.values); l != "" {
+			clauses = append(clauses, fmt.Sprintf("%s in (%s)", in.field, l))
+		}
+	}
+	clauses = append(clauses, extra...)
+
+	jql := strings.Join(clauses, " AND ")
+	if order != "" {
+		jql = fmt.Sprintf("%s %s", jql, order)
+	}
+
+	return jql
+}
+
+// splitOrderBy separates a trailing ORDER BY from a jql query so the query can be wrapped in parentheses.
+// an order by inside a quoted value is part of the value
+func splitOrderBy(q string) (string, string) {
+	q = strings.TrimSpace(q)
+	lq := strings.ToLower(q)
+
+	i := -1
+	var quote byte
+	for x := 0; x < len(q); x++ {
+		switch c := q[x]; {
+		case quote != 0 && c == '\\':
+			x++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case strings.HasPrefix(lq[x:], "order by"):
+			i = x
+		}
+	}
+	if i == -1 {
+		return q, ""
+	}
+	return strings.TrimSpace(q[:i]), strings.TrimSpace(q[i:])
+}
+
+// quoteJQL quotes a single value for use in a jql clause
+func quoteJQL(v string) string {
+	v = strings.Replace(strings.TrimSpace(v), `"`, `\"`, -1)
+	return fmt.Sprintf(`"%s"`, v)
+}
+
+func quoteJQLList(vs []string) string {
+	var q []string
+	for _, v := range vs {
+		if strings.TrimSpace(v) != "" {
+			q = append(q, quoteJQL(v))
+		}
+	}
+	return strings.Join(q, ", ")
+}
@@ -0,0 +1,30 @@
+package migrate
+
+import "testing"
+
+func TestSplitOrderBy(t *testing.T) {
+	tests := []struct {
+		q, base, order string
+	}{
+		{"project = PIG", "project = PIG", ""},
+		{"project = PIG ORDER BY key ASC", "project = PIG", "ORDER BY key ASC"},
+		{`summary ~ "order by date" order by created`, `summary ~ "order by date"`, "order by created"},
+		{`summary ~ "order by date"`, `summary ~ "order by date"`, ""},
+		{`summary ~ 'say \'order by\''`, `summary ~ 'say \'order by\''`, ""},
+	}
+	for _, tt := range tests {
+		if b, o := splitOrderBy(tt.q); b != tt.base || o != tt.order {
+			t.Errorf("splitOrderBy(%q) = %q, %q", tt.q, b, o)
+		}
+	}
+}
+
+func TestBuildJQLBlankList(t *testing.T) {
+	was := statuses
+	defer func() { statuses = was }()
+	statuses = []string{" ", ""}
+
+	if q := buildJQL(ProjectConfig{Name: "PIG"}); q != `project = "PIG"` {
+		t.Errorf("jql %q", q)
+	}
+}
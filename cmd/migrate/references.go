@@ -0,0 +1,215 @@
+package migrate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"github.com/wianvos/pigmy/cmd/store"
+	utils "github.com/wianvos/pigmy/cmd/utils"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// issueKeyPattern matches jira issue keys like PRO-123
+var issueKeyPattern = regexp.MustCompile(`\b([A-Z][A-Z0-9_]+)-([0-9]+)\b`)
+
+// githubKeyPattern matches github issue references like owner/repo#123
+var githubKeyPattern = regexp.MustCompile(`\b[\w.-]+/[\w.-]+#[0-9]+\b`)
+
+// githubURLPattern matches links to github issues, owner, repo and number in the submatches
+var githubURLPattern = regexp.MustCompile(`https?://[^\s/]+/([\w.-]+)/([\w.-]+)/issues/([0-9]+)\b`)
+
+// otherProjects caches the migration state of the projects referenced from the one being migrated
+var (
+	otherProjects   = make(map[string]*store.Store)
+	otherProjectsMu sync.Mutex
+)
+
+// translate converts jira markup to gitlab markdown and rewrites jira issue references.
+// the second return value tells if the text references issues that are not migrated yet
+func (p *Project) translate(t string) (string, bool) {
+	return p.rewriteReferences(translateText(t))
+}
+
+// rewriteReferences replaces jira browse urls and issue keys, and their github counterparts, with gitlab
+// issue references. only keys of projects pigmy knows about are touched, so things like UTF-8 are left alone
+func (p *Project) rewriteReferences(t string) (string, bool) {
+	pending := false
+
+	replace := func(k string) (string, bool) {
+		r, known, ok := p.reference(k)
+		if known && !ok {
+			pending = true
+		}
+		return r, ok
+	}
+
+	// full urls first, otherwise the key inside them gets rewritten and the url is left dangling
+	t = browseURLPattern().ReplaceAllStringFunc(t, func(u string) string {
+		if r, ok := replace(issueKeyPattern.FindString(u)); ok {
+			return r
+		}
+		return u
+	})
+
+	t = githubURLPattern.ReplaceAllStringFunc(t, func(u string) string {
+		m := githubURLPattern.FindStringSubmatch(u)
+		if r, ok := replace(fmt.Sprintf("%s/%s#%s", m[1], m[2], m[3])); ok {
+			return r
+		}
+		return u
+	})
+
+	for _, kp := range []*regexp.Regexp{githubKeyPattern, issueKeyPattern} {
+		t = kp.ReplaceAllStringFunc(t, func(k string) string {
+			if r, ok := replace(k); ok {
+				return r
+			}
+			return k
+		})
+	}
+
+	return t, pending
+}
+
+// reference returns the gitlab reference for jira issue key k, or github's owner/repo#number.
+// known tells if k belongs to a project we migrate, ok if the issue itself has been migrated
+func (p *Project) reference(k string) (r string, known bool, ok bool) {
+	var pk string
+	if x := strings.Index(k, "#"); x != -1 {
+		// github projects are named after the repository, and pull requests numbered along with the issues
+		pk = k[strings.Index(k, "/")+1 : x]
+		if gs, isGithub := p.source().(*githubSource); isGithub && !gs.has(k) {
+			return k, false, false
+		}
+	} else {
+		pk = k[:strings.LastIndex(k, "-")]
+	}
+
+	if pk == p.Name {
+		if m := p.Mapping.IssueByKey(k); m != nil {
+			return fmt.Sprintf("#%d", m.IID), true, true
+		}
+		return k, true, false
+	}
+
+	st := otherProject(p.Mapping.Target, pk)
+	if st == nil {
+		return k, false, false
+	}
+	m := st.IssueByKey(k)
+	if m == nil {
+		return k, true, false
+	}
+	// issues that ended up in the same gitlab project don't need the path
+	if st.GitlabPID == p.Pid || st.GitlabPath == "" {
+		return fmt.Sprintf("#%d", m.IID), true, true
+	}
+	return fmt.Sprintf("%s#%d", st.GitlabPath, m.IID), true, true
+}
+
+// otherProject loads the migration state of jira project pk for targets of kind t, nil when it was never
+// migrated to one. issues migrated elsewhere can't be referenced
+func otherProject(t, pk string) *store.Store {
+	otherProjectsMu.Lock()
+	defer otherProjectsMu.Unlock()
+
+	if st, ok := otherProjects[t+"/"+pk]; ok {
+		return st
+	}
+
+	st, err := store.LoadTarget(t, pk)
+	if err != nil || len(st.Issues) == 0 {
+		st = nil
+	}
+	otherProjects[t+"/"+pk] = st
+	return st
+}
+
+// browseURLPattern matches links to issues on the jira server we migrate from
+func browseURLPattern() *regexp.Regexp {
+	base := `https?://[^\s\]|)]+`
+	if u := strings.TrimSuffix(viper.GetString("jiraURL"), "/"); u != "" {
+		base = regexp.QuoteMeta(u)
+	}
+	return regexp.MustCompile(base + `/browse/[A-Z][A-Z0-9_]+-[0-9]+\b`)
+}
+
+// hasPendingReferences tells if the issue text references issues that are not migrated yet
+func (p *Project) hasPendingReferences(i *Issue) bool {
+	if _, pending := p.rewriteReferences(i.Description); pending {
+		return true
+	}
+	for _, c := range i.Comments {
+		if _, pending := p.rewriteReferences(c.Body); pending {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingIssues returns the migrated issues whose references are still pending. next to the issues of this
+// run those are the ones earlier runs left behind, referencing projects that might have been migrated since
+func (p *Project) pendingIssues() []*Issue {
+	var is []*Issue
+	seen := make(map[string]bool)
+	for x := range p.Issues {
+		i := &p.Issues[x]
+		seen[i.JiraID] = true
+		if m := p.Mapping.Issue(i.JiraID); m != nil && m.PendingRefs {
+			is = append(is, i)
+		}
+	}
+
+	for id, m := range p.Mapping.Issues {
+		if !m.PendingRefs || seen[id] {
+			continue
+		}
+		i, err := p.source().Issue(id)
+		if err != nil {
+			contextLogger.WithError(err).WithField("JiraIssueID", id).Error("unable to fetch issue to rewrite its references")
+			continue
+		}
+		is = append(is, &i)
+	}
+	return is
+}
+
+// ResolveForwardReferences is the second pass over the migrated issues. references to issues that
+// were created later in the run, or in a later run, could not be rewritten the first time around, they can now
+func (p *Project) ResolveForwardReferences() {
+	glc := utils.GetGitlabClient()
+
+	for _, i := range p.pendingIssues() {
+		m := p.Mapping.Issue(i.JiraID)
+		contextLogger := contextLogger.WithField("JiraIssueID", i.JiraID)
+
+		d := p.issueBody(i)
+		_, _, err := glc.Issues.UpdateIssue(p.Pid, m.IID, &gitlab.UpdateIssueOptions{Description: &d})
+		if err != nil {
+			contextLogger.WithError(err).Error("unable to rewrite references in description")
+			continue
+		}
+
+		for _, c := range i.Comments {
+			n := m.Comments[c.JiraID]
+			if n == 0 {
+				continue
+			}
+			b := p.commentBody(i, c)
+			_, _, err := glc.Notes.UpdateIssueNote(p.Pid, m.IID, n, &gitlab.UpdateIssueNoteOptions{Body: &b})
+			if err != nil {
+				contextLogger.WithError(err).WithField("JiraComment", c.JiraID).Error("unable to rewrite references in comment")
+			}
+		}
+
+		m.PendingRefs = p.hasPendingReferences(i)
+		contextLogger.Info("references rewritten")
+	}
+
+	if err := p.Mapping.Save(); err != nil {
+		contextLogger.WithError(err).Error("unable to save migration state")
+	}
+}
@@ -0,0 +1,287 @@
+package migrate
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/wianvos/pigmy/cmd/store"
+	utils "github.com/wianvos/pigmy/cmd/utils"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// impersonationLifetime is how long a minted impersonation token lives, should revoking it fail
+const impersonationLifetime = 48 * time.Hour
+
+var (
+	currentRun *store.Run
+	runMu      sync.Mutex
+)
+
+// ExitOnSignal has an interrupt end the run and exit, programs embedding the migration handle signals themselves
+var ExitOnSignal = true
+
+// observers are called with every journal entry
+var (
+	observers   = make(map[int]func(*store.Entry))
+	observerSeq int
+	observersMu sync.Mutex
+)
+
+// impersonation is a minted token and the client using it
+type impersonation struct {
+	client *gitlab.Client
+	minted time.Time
+}
+
+var (
+	impersonations   = make(map[string]*impersonation)
+	impersonationsMu sync.Mutex
+)
+
+// BeginRun starts recording a run of command c. whatever abandoned runs left behind (tokens, silenced
+// notifications) is cleaned up first, and an interrupt ends the run properly before exiting
+func BeginRun(c string) (*store.Run, error) {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	if currentRun != nil {
+		return currentRun, nil
+	}
+
+	cleanupAbandonedRuns()
+
+	r, err := store.NewRun(c)
+	if err != nil {
+		return nil, err
+	}
+	currentRun = r
+	contextLogger.WithField("run", r.ID).Info("run started")
+	fmt.Fprintf(out, "run %s, journal in %s\n", r.ID, r.JournalPath())
+
+	if ExitOnSignal {
+		sc := make(chan os.Signal, 1)
+		signal.Notify(sc, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			s := <-sc
+			contextLogger.Warnf("received %s, ending the run", s)
+			EndRun()
+			os.Exit(130)
+		}()
+	}
+
+	return r, nil
+}
+
+// Reset forgets what the package cached about the settings, jira and gitlab in use: the authorship mode,
+// author tokens, gitlab users and the state of referenced projects. programs that migrate with different
+// settings in one process call it in between runs
+func Reset() {
+	userClientsMu.Lock()
+	authorOnce = sync.Once{}
+	authorErr = nil
+	authorMode = ""
+	headerTemplate = nil
+	userTokens = nil
+	userClients = make(map[string]*gitlab.Client)
+	userClientsMu.Unlock()
+
+	gitlabUsersMu.Lock()
+	gitlabUsers = make(map[string]*gitlab.User)
+	gitlabUsersMu.Unlock()
+
+	otherProjectsMu.Lock()
+	otherProjects = make(map[string]*store.Store)
+	otherProjectsMu.Unlock()
+
+	impersonationsMu.Lock()
+	impersonations = make(map[string]*impersonation)
+	impersonationsMu.Unlock()
+}
+
+// EndRun restores the notification settings, revokes the tokens minted during the run and marks it finished
+func EndRun() {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	if currentRun == nil {
+		return
+	}
+	restoreNotifications(currentRun)
+	revokeTokens(currentRun)
+	currentRun.Finished = time.Now()
+	if err := currentRun.Save(); err != nil {
+		contextLogger.WithError(err).Error("unable to save the run state")
+	}
+	currentRun = nil
+}
+
+// activeRun returns the run in progress, nil when there is none. batch workers journal
+// alongside each other and the run might be ending while they do
+func activeRun() *store.Run {
+	runMu.Lock()
+	defer runMu.Unlock()
+	return currentRun
+}
+
+// exit ends the run before exiting, deferred functions don't survive os.Exit
+func exit(code int) {
+	EndRun()
+	os.Exit(code)
+}
+
+// cleanupAbandonedRuns does what runs that crashed couldn't do themselves
+func cleanupAbandonedRuns() {
+	rs, err := store.Runs()
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to read the run state")
+		return
+	}
+	for _, r := range rs {
+		if !r.Abandoned() {
+			continue
+		}
+		contextLogger.WithField("run", r.ID).Warn("cleaning up after abandoned run")
+		restoreNotifications(r)
+		revokeTokens(r)
+		// once nothing is left behind the run is done with, otherwise the next start tries again
+		if cleanedUp(r) {
+			r.Finished = time.Now()
+		}
+		if err := r.Save(); err != nil {
+			contextLogger.WithError(err).Error("unable to save the run state")
+		}
+	}
+}
+
+// cleanedUp tells if run r restored every notification setting and revoked every token it changed
+func cleanedUp(r *store.Run) bool {
+	for _, n := range r.Notifications {
+		if !n.Restored {
+			return false
+		}
+	}
+	for _, t := range r.Tokens {
+		if !t.Revoked {
+			return false
+		}
+	}
+	return true
+}
+
+// revokeTokens revokes every impersonation token of run r not revoked yet
+func revokeTokens(r *store.Run) {
+	glc := utils.GetGitlabClient()
+
+	for _, t := range r.Tokens {
+		if t.Revoked {
+			continue
+		}
+		resp, err := glc.Users.RevokeImpersonationToken(t.UserID, t.TokenID)
+		// gone already is just as good
+		if err != nil && (resp == nil || resp.StatusCode != 404) {
+			contextLogger.WithError(err).Errorf("unable to revoke impersonation token %d of %s", t.TokenID, t.Username)
+			continue
+		}
+		t.Revoked = true
+	}
+
+	impersonationsMu.Lock()
+	impersonations = make(map[string]*impersonation)
+	impersonationsMu.Unlock()
+}
+
+// journal logs the outcome of action a on o, started at t, in the journal of the current run
+func journal(a string, o *store.Object, t time.Time, err error) {
+	r := activeRun()
+	if r == nil {
+		return
+	}
+	e := &store.Entry{
+		Time:       t,
+		Action:     a,
+		Result:     store.ResultOK,
+		DurationMS: int64(time.Since(t) / time.Millisecond),
+		Object:     *o,
+	}
+	if err != nil {
+		e.Result = store.ResultFailed
+		e.Error = err.Error()
+	}
+	if err := r.Log(e); err != nil {
+		contextLogger.WithError(err).Errorf("unable to journal the %s of a %s", a, o.Kind)
+	}
+
+	observersMu.Lock()
+	defer observersMu.Unlock()
+	for _, f := range observers {
+		f(e)
+	}
+}
+
+// Observe has f called with every entry journaled from now on, until the returned function is called.
+// f is called from whatever goroutine does the journaling, it shouldn't take long
+func Observe(f func(*store.Entry)) func() {
+	observersMu.Lock()
+	defer observersMu.Unlock()
+
+	observerSeq++
+	id := observerSeq
+	observers[id] = f
+	return func() {
+		observersMu.Lock()
+		defer observersMu.Unlock()
+		delete(observers, id)
+	}
+}
+
+// impersonate returns a client acting as gitlab user u, minting an impersonation token when needed.
+// nil when u is unknown to gitlab or no token could be minted
+func impersonate(u string) *gitlab.Client {
+	// before taking the lock, ending the run takes them the other way around
+	r := activeRun()
+
+	impersonationsMu.Lock()
+	defer impersonationsMu.Unlock()
+
+	// mint a fresh one well before the old one expires, the webhook sync runs for days
+	if i, ok := impersonations[u]; ok && time.Since(i.minted) < impersonationLifetime/2 {
+		return i.client
+	}
+
+	gu := gitlabUserGet(u)
+	if gu == nil {
+		return nil
+	}
+	if r == nil {
+		contextLogger.Errorf("no run to record an impersonation token for %s in", u)
+		return nil
+	}
+
+	glc := utils.GetGitlabClient()
+	n := fmt.Sprintf("pigmy-%s", r.ID)
+	sc := []string{"api"}
+	ex := time.Now().Add(impersonationLifetime)
+	t, _, err := glc.Users.CreateImpersonationToken(gu.ID, &gitlab.CreateImpersonationTokenOptions{
+		Name:      &n,
+		Scopes:    &sc,
+		ExpiresAt: &ex,
+	})
+	if err != nil {
+		contextLogger.WithError(err).Errorf("unable to mint an impersonation token for %s", u)
+		return nil
+	}
+
+	// record it before using it, so a crash can't leave it behind unnoticed
+	if err := r.AddToken(&store.Token{Username: u, UserID: gu.ID, TokenID: t.ID}); err != nil {
+		contextLogger.WithError(err).Error("unable to record the impersonation token")
+	}
+
+	c := gitlab.NewClient(nil, t.Token)
+	c.SetBaseURL(glc.BaseURL().String())
+	impersonations[u] = &impersonation{client: c, minted: time.Now()}
+	return c
+}
@@ -0,0 +1,115 @@
+package migrate
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	utils "github.com/wianvos/pigmy/cmd/utils"
+)
+
+// Source is where the issues and users of a jira project are extracted from
+type Source interface {
+	// IssueIDs lists the issues of project c to migrate, only those updated since when since is set
+	IssueIDs(c ProjectConfig, since time.Time) ([]string, error)
+	// Issue reads issue id in full, attachements included
+	Issue(id string) (Issue, error)
+	// User looks up jira user n
+	User(n string) (User, error)
+}
+
+// addSourceFlags registers the flags selecting an export to migrate from instead of the live jira
+func addSourceFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("export", "", "jira xml or csv export to migrate from instead of the jira api")
+	cmd.PersistentFlags().String("export-attachments", "", "directory holding the attachments of the export")
+	cmd.PersistentFlags().String("export-email-domain", "", "mail domain for users the export has no address of, defaults to the host of the jira url")
+	viper.BindPFlag("export", cmd.PersistentFlags().Lookup("export"))
+	viper.BindPFlag("exportAttachments", cmd.PersistentFlags().Lookup("export-attachments"))
+	viper.BindPFlag("exportEmailDomain", cmd.PersistentFlags().Lookup("export-email-domain"))
+}
+
+// openSource returns the source of project c, the jira api unless an export or github archive is given
+func openSource(c ProjectConfig) (Source, error) {
+	if c.GithubArchive != "" {
+		return loadGithubArchive(c.GithubArchive)
+	}
+
+	f := viper.GetString("export")
+	if f == "" {
+		return liveSource{}, nil
+	}
+
+	d := viper.GetString("exportEmailDomain")
+	if d == "" {
+		if u, err := url.Parse(viper.GetString("jiraURL")); err == nil {
+			d = u.Hostname()
+		}
+	}
+	if d == "" {
+		return nil, fmt.Errorf("the export has no mail addresses, set exportEmailDomain")
+	}
+
+	return loadExport(f, viper.GetString("exportAttachments"), d)
+}
+
+// source returns the source of the project, projects not fetched through one talk to jira
+func (p *Project) source() Source {
+	if p.Source == nil {
+		return liveSource{}
+	}
+	return p.Source
+}
+
+// liveSource extracts projects through the jira rest api
+type liveSource struct{}
+
+func (liveSource) IssueIDs(c ProjectConfig, since time.Time) ([]string, error) {
+	var extra []string
+	if !since.IsZero() {
+		extra = append(extra, fmt.Sprintf("updated >= %s", quoteJQL(jqlTime(since))))
+	}
+
+	jql := buildJQL(c, extra...)
+	contextLogger.Infof("selecting issues using jql: %s", jql)
+
+	is, err := SearchIssues(jql)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(is))
+	for _, i := range is {
+		ids = append(ids, i.ID)
+	}
+	return ids, nil
+}
+
+// jqlTime formats t for jql. jira reads the dates in jql in the time zone of the user running the query,
+// which need not be the zone of this machine. when that zone is unknown a day is taken off t, an issue
+// selected once too often is brought up to date again rather than missed
+func jqlTime(t time.Time) string {
+	var me struct {
+		TimeZone string `json:"timeZone"`
+	}
+	err := jiraGet(utils.GetJiraClient(), "rest/api/2/myself", &me)
+	if err == nil && me.TimeZone == "" {
+		err = fmt.Errorf("jira names no time zone")
+	}
+	if err == nil {
+		var loc *time.Location
+		if loc, err = time.LoadLocation(me.TimeZone); err == nil {
+			return t.In(loc).Format(jqlTimeFormat)
+		}
+	}
+	contextLogger.WithError(err).Warnf("unable to find the time zone of the jira user, selecting issues updated since a day before %s", t)
+	return t.Add(-24 * time.Hour).Format(jqlTimeFormat)
+}
+
+func (liveSource) Issue(id string) (Issue, error) {
+	return FetchIssue(id)
+}
+
+func (liveSource) User(n string) (User, error) {
+	return jiraGetUser(n)
+}
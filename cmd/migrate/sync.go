@@ -0,0 +1,141 @@
+package migrate
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/wianvos/pigmy/cmd/store"
+	utils "github.com/wianvos/pigmy/cmd/utils"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// deletedLabel marks gitlab issues whose jira counterpart was deleted
+const deletedLabel = "deleted-in-jira"
+
+// ErrNotMigrated is returned when a project is loaded that pigmy has not migrated yet
+var ErrNotMigrated = errors.New("project has not been migrated yet")
+
+// LoadProject returns a previously migrated project, ready to have single issues applied to it
+func LoadProject(name string) (*Project, error) {
+	st, err := store.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	if st.GitlabPID == 0 {
+		return nil, ErrNotMigrated
+	}
+
+	d, err := configDefaults()
+	if err != nil {
+		return nil, err
+	}
+	c := ProjectConfig{Name: name}.merge(d)
+
+	return &Project{Name: name, Pid: st.GitlabPID, Config: c, Mapping: st}, nil
+}
+
+// SyncIssue fetches issue id from the source of the project and creates or updates its gitlab counterpart
+func (p *Project) SyncIssue(id string) error {
+	if err := loadAuthorship(); err != nil {
+		return err
+	}
+	if p.Source == nil {
+		src, err := openSource(p.Config)
+		if err != nil {
+			return err
+		}
+		p.Source = src
+	}
+	i, err := p.Source.Issue(id)
+	if err != nil {
+		return err
+	}
+	i.Labels = p.Config.labels(i)
+
+	// the issue might bring along users we have never seen
+	ip := Project{Pid: p.Pid, Name: p.Name, Issues: Issues{i}, Source: p.Source}
+	ip.PopulateUsers()
+	if err := ip.Users.Create(p); err != nil {
+		return err
+	}
+
+	if err := i.Create(p); err != nil {
+		return err
+	}
+	return p.Mapping.Save()
+}
+
+// DeleteIssue closes and labels the gitlab counterpart of a deleted jira issue.
+// the gitlab issue itself is kept, deleting it would destroy history we can't bring back
+func (p *Project) DeleteIssue(id string) error {
+	contextLogger := contextLogger.WithFields(log.Fields{"JiraIssueID": id})
+
+	m := p.Mapping.Issue(id)
+	if m == nil {
+		contextLogger.Info("deleted issue was never migrated, nothing to do")
+		return nil
+	}
+
+	glc := utils.GetGitlabClient()
+
+	gi, _, err := glc.Issues.GetIssue(p.Pid, m.IID)
+	if err != nil {
+		return err
+	}
+
+	cs := "close"
+	labels := append(gi.Labels, deletedLabel)
+	t := time.Now()
+	_, _, err = glc.Issues.UpdateIssue(p.Pid, m.IID, &gitlab.UpdateIssueOptions{StateEvent: &cs, Labels: labels})
+	journal(store.ActionClose, &store.Object{Kind: store.ObjectIssue, ProjectID: p.Pid, IssueIID: m.IID, JiraProject: p.Name, JiraID: id, JiraKey: m.JiraKey}, t, err)
+	if err != nil {
+		return err
+	}
+
+	contextLogger.Info("issue closed after deletion in jira")
+	return nil
+}
+
+// SyncComment brings the note of an edited jira comment in line.
+// comments we never migrated are picked up by syncing the whole issue
+func (p *Project) SyncComment(issueID, commentID, body string) error {
+	contextLogger := contextLogger.WithFields(log.Fields{"JiraIssueID": issueID, "JiraComment": commentID})
+
+	m := p.Mapping.Issue(issueID)
+	if m != nil && m.WriteBack != nil && m.WriteBack.CommentID == commentID {
+		contextLogger.Debug("skipping the write back comment")
+		return nil
+	}
+	if m == nil || m.Comments[commentID] == 0 {
+		return p.SyncIssue(issueID)
+	}
+
+	if err := loadAuthorship(); err != nil {
+		return err
+	}
+	glc := utils.GetGitlabClient()
+
+	b, _ := p.translate(body)
+	if authorMode == authorshipHeader {
+		// the header needs the author and date the webhook leaves out
+		jlc := utils.GetJiraClient()
+		ji, _, err := jlc.Issue.Get(issueID, nil)
+		if err != nil {
+			return err
+		}
+		i := NewIssue(ji)
+		for _, c := range i.Comments {
+			if c.JiraID == commentID {
+				b = p.commentBody(&i, c)
+			}
+		}
+	}
+	_, _, err := glc.Notes.UpdateIssueNote(p.Pid, m.IID, m.Comments[commentID], &gitlab.UpdateIssueNoteOptions{Body: &b})
+	if err != nil {
+		return err
+	}
+
+	contextLogger.Info("comment updated")
+	return nil
+}
@@ -0,0 +1,60 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/wianvos/pigmy/cmd/store"
+)
+
+// Target is where a project is loaded into
+type Target interface {
+	// Migrate loads project p with its users, issues, comments and attachements
+	Migrate(p *Project) error
+}
+
+// addTargetFlags registers the flags selecting what a project is migrated to
+func addTargetFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("target", "gitlab", "what to migrate to: gitlab, gitea (or forgejo), or archive (a static markdown archive)")
+	cmd.PersistentFlags().String("archive-dir", "archive", "directory the archive target writes to")
+	viper.BindPFlag("target", cmd.PersistentFlags().Lookup("target"))
+	viper.BindPFlag("archiveDir", cmd.PersistentFlags().Lookup("archive-dir"))
+}
+
+// openTarget returns the target the config points to
+func openTarget() (Target, error) {
+	switch t := viper.GetString("target"); t {
+	case "", "gitlab":
+		return gitlabTarget{}, nil
+	case "gitea", "forgejo":
+		return &giteaTarget{}, nil
+	case "archive":
+		return archiveTarget{Dir: viper.GetString("archiveDir")}, nil
+	default:
+		return nil, fmt.Errorf("unknown target %s", t)
+	}
+}
+
+// targetKind returns the kind of target the config points to, which tells whose migration state to use
+func targetKind() string {
+	switch viper.GetString("target") {
+	case "gitea", "forgejo":
+		return store.TargetGitea
+	case "archive":
+		return store.TargetArchive
+	}
+	return store.TargetGitlab
+}
+
+// gitlabTarget migrates projects to gitlab
+type gitlabTarget struct{}
+
+// canWriteBack tells if the issues came from a live jira and went somewhere jira can point to
+func (p *Project) canWriteBack() bool {
+	if _, ok := p.source().(liveSource); !ok {
+		return false
+	}
+	_, ok := p.Target.(archiveTarget)
+	return !ok
+}
@@ -0,0 +1,263 @@
+package migrate
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	jira "github.com/wianvos/go-jira"
+	"github.com/wianvos/pigmy/cmd/store"
+	utils "github.com/wianvos/pigmy/cmd/utils"
+)
+
+// write back options, all of them off by default
+var (
+	writeBackComment    bool
+	writeBackLink       bool
+	writeBackLabel      string
+	writeBackTransition string
+)
+
+// remoteLinkApp identifies the remote links pigmy creates, jira updates a link with the same global id instead of adding another
+const remoteLinkApp = "pigmy"
+
+// AddWriteBackFlags registers the write back flags on the given command
+func AddWriteBackFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVar(&writeBackComment, "writeback-comment", false, "add a comment to every migrated jira issue pointing to its gitlab issue")
+	cmd.PersistentFlags().BoolVar(&writeBackLink, "writeback-link", false, "add a remote link to every migrated jira issue pointing to its gitlab issue")
+	cmd.PersistentFlags().StringVar(&writeBackLabel, "writeback-label", "", "label to add to every migrated jira issue")
+	cmd.PersistentFlags().StringVar(&writeBackTransition, "writeback-transition", "", "transition every migrated jira issue to this status, matched on the transition or the status it leads to")
+}
+
+// writeBackEnabled tells if any write back was asked for
+func writeBackEnabled() bool {
+	return writeBackComment || writeBackLink || writeBackLabel != "" || writeBackTransition != ""
+}
+
+// WriteBackOptions are the write back flags, for programs that have no command line
+type WriteBackOptions struct {
+	Comment    bool
+	Link       bool
+	Label      string
+	Transition string
+}
+
+// SetWriteBack sets the write back options the way the write back flags do, it returns the options set until now
+func SetWriteBack(o WriteBackOptions) WriteBackOptions {
+	was := WriteBackOptions{Comment: writeBackComment, Link: writeBackLink, Label: writeBackLabel, Transition: writeBackTransition}
+	writeBackComment, writeBackLink, writeBackLabel, writeBackTransition = o.Comment, o.Link, o.Label, o.Transition
+	return was
+}
+
+// WriteBackIfEnabled points the jira issues of the project to their new home, when that was asked for
+// and the issues came from a jira that is still around
+func (p *Project) WriteBackIfEnabled() {
+	if writeBackEnabled() && p.canWriteBack() {
+		p.WriteBack()
+	}
+}
+
+//create the command and add it to the migrateCMD objects
+func addWriteBack() {
+	cmd := &cobra.Command{
+		Use:   "writeback <project>",
+		Short: "point the issues of an already migrated jira project to their gitlab issues",
+		Run:   runWriteBack,
+	}
+
+	migrateCMD.AddCommand(cmd)
+}
+
+func runWriteBack(cmd *cobra.Command, args []string) {
+
+	contextLogger = contextLogger.WithFields(log.Fields{"subcommand": "WriteBack"})
+	if len(args) != 1 {
+		contextLogger.Fatal("need a project name to write back to")
+		os.Exit(2)
+	}
+
+	if !writeBackEnabled() {
+		fmt.Println("nothing to write back, use one or more of the --writeback flags")
+		os.Exit(2)
+	}
+
+	p, err := LoadProject(args[0])
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to load project")
+		fmt.Printf("unable to load project %s: %s\n", args[0], err)
+		os.Exit(2)
+	}
+
+	if _, err := BeginRun("migrate writeback " + args[0]); err != nil {
+		contextLogger.WithError(err).Error("unable to record the run")
+		fmt.Printf("unable to record the run: %s .. exiting\n", err)
+		os.Exit(2)
+	}
+
+	if e := p.WriteBack(); e != 0 {
+		exit(1)
+	}
+	EndRun()
+}
+
+// WriteBack points every migrated jira issue of the project to its gitlab issue.
+// what was written back is recorded in the mapping store, so running it again only does what is missing
+func (p *Project) WriteBack() int {
+	contextLogger := contextLogger.WithField("Project", p.Name)
+
+	fmt.Fprintln(out, "writing back gitlab links to jira")
+	bar := newProgress(len(p.Mapping.Issues))
+
+	e := 0
+	for _, m := range p.Mapping.Issues {
+		bar.Add(1)
+		t := time.Now()
+		err := writeBackIssue(m)
+		journal(store.ActionWriteBack, &store.Object{Kind: store.ObjectJiraIssue, ProjectID: p.Pid, IssueIID: m.IID, JiraProject: p.Name, JiraID: m.JiraID, JiraKey: m.JiraKey}, t, err)
+		if err != nil {
+			contextLogger.WithError(err).WithField("JiraIssue", m.JiraKey).Error("unable to write back to jira")
+			e = e + 1
+		}
+		// persist after every issue, whatever got written back should never be written again
+		if err := p.Mapping.Save(); err != nil {
+			contextLogger.WithError(err).Error("unable to save migration state")
+		}
+	}
+
+	fmt.Fprintf(out, "\nwrote back %d issues. %d errors encountered\n", len(p.Mapping.Issues)-e, e)
+	return e
+}
+
+// WriteBackIssue does the write back for a single migrated jira issue
+func (p *Project) WriteBackIssue(id string) error {
+	m := p.Mapping.Issue(id)
+	if m == nil {
+		return fmt.Errorf("jira issue %s was never migrated", id)
+	}
+
+	t := time.Now()
+	err := writeBackIssue(m)
+	journal(store.ActionWriteBack, &store.Object{Kind: store.ObjectJiraIssue, ProjectID: p.Pid, IssueIID: m.IID, JiraProject: p.Name, JiraID: m.JiraID, JiraKey: m.JiraKey}, t, err)
+	if err != nil {
+		return err
+	}
+	return p.Mapping.Save()
+}
+
+// writeBackIssue does the write back steps not done before for a single issue
+func writeBackIssue(m *store.Issue) error {
+	if m.WebURL == "" {
+		return fmt.Errorf("no gitlab url known for %s", m.JiraKey)
+	}
+	if m.WriteBack == nil {
+		m.WriteBack = &store.WriteBack{}
+	}
+	wb := m.WriteBack
+	jlc := utils.GetJiraClient()
+
+	if writeBackComment && wb.CommentID == "" {
+		c, _, err := jlc.Issue.AddComment(m.JiraID, &jira.Comment{
+			Body: fmt.Sprintf("This issue has been migrated to GitLab: %s", m.WebURL),
+		})
+		if err != nil {
+			return err
+		}
+		wb.CommentID = c.ID
+		// the comment is ours, it must never come back as a note
+		if m.Comments == nil {
+			m.Comments = make(map[string]int)
+		}
+		m.Comments[c.ID] = 0
+	}
+
+	if writeBackLink && !wb.RemoteLink {
+		l := map[string]interface{}{
+			"globalId":    fmt.Sprintf("%s=%s", remoteLinkApp, m.WebURL),
+			"application": map[string]string{"type": remoteLinkApp, "name": "GitLab"},
+			"object": map[string]interface{}{
+				"url":   m.WebURL,
+				"title": fmt.Sprintf("GitLab #%d", m.IID),
+			},
+		}
+		if err := jiraRequest(jlc, "POST", fmt.Sprintf("rest/api/2/issue/%s/remotelink", m.JiraID), l); err != nil {
+			return err
+		}
+		wb.RemoteLink = true
+	}
+
+	if writeBackLabel != "" && wb.Label != writeBackLabel {
+		u := map[string]interface{}{
+			"update": map[string]interface{}{
+				"labels": []map[string]string{{"add": writeBackLabel}},
+			},
+		}
+		if err := jiraRequest(jlc, "PUT", fmt.Sprintf("rest/api/2/issue/%s", m.JiraID), u); err != nil {
+			return err
+		}
+		wb.Label = writeBackLabel
+	}
+
+	if writeBackTransition != "" && wb.Transitioned != writeBackTransition {
+		if err := transitionIssue(jlc, m.JiraID, writeBackTransition); err != nil {
+			return err
+		}
+		wb.Transitioned = writeBackTransition
+	}
+
+	ji, _, err := jlc.Issue.Get(m.JiraID, &jira.GetQueryOptions{Fields: "updated"})
+	if err != nil {
+		return err
+	}
+	wb.Updated = time.Time(ji.Fields.Updated)
+	return nil
+}
+
+// jiraTransition is a workflow transition and the status it leads to
+type jiraTransition struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	To   struct {
+		Name string `json:"name"`
+	} `json:"to"`
+}
+
+// transitionIssue moves a jira issue to status s, along the transition named s or the one leading to s.
+// an issue already in status s needs no transition
+func transitionIssue(jlc *jira.Client, id, s string) error {
+	ji, _, err := jlc.Issue.Get(id, nil)
+	if err != nil {
+		return err
+	}
+	if ji.Fields.Status != nil && strings.EqualFold(ji.Fields.Status.Name, s) {
+		return nil
+	}
+
+	ts := struct {
+		Transitions []jiraTransition `json:"transitions"`
+	}{}
+	if err := jiraGet(jlc, fmt.Sprintf("rest/api/2/issue/%s/transitions", id), &ts); err != nil {
+		return err
+	}
+
+	for _, t := range ts.Transitions {
+		if strings.EqualFold(t.Name, s) || strings.EqualFold(t.To.Name, s) {
+			_, err := jlc.Issue.DoTransition(id, t.ID)
+			return err
+		}
+	}
+
+	return fmt.Errorf("no transition to %s available for issue %s", s, id)
+}
+
+// jiraRequest sends a request the jira client has no method for
+func jiraRequest(jlc *jira.Client, method, url string, body interface{}) error {
+	req, err := jlc.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	_, err = jlc.Do(req, nil)
+	return err
+}
@@ -0,0 +1,221 @@
+package redirects
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/wianvos/pigmy/cmd/store"
+)
+
+var redirectsCMD = &cobra.Command{
+	Use:   "redirects [project]...",
+	Short: "generate jira key to gitlab issue redirects (defaults to every migrated project)",
+	Run:   runRedirects,
+}
+
+var contextLogger = log.WithFields(log.Fields{"Command": "Redirects"})
+
+var format string
+var output string
+var serve string
+
+// Entry redirects a single jira issue key to its gitlab issue
+type Entry struct {
+	Key string
+	IID int
+	URL string
+}
+
+//GetCommands grab and return commands in this package
+func GetCommands() *cobra.Command {
+
+	redirectsCMD.Flags().StringVar(&format, "format", "nginx", "redirect map format: nginx, apache or csv")
+	redirectsCMD.Flags().StringVar(&output, "output", "", "write the redirect map to this file instead of stdout")
+	redirectsCMD.Flags().StringVar(&serve, "serve", "", "instead of writing a map, serve the redirects on this address (e.g. :8081)")
+
+	return redirectsCMD
+}
+
+func runRedirects(cmd *cobra.Command, args []string) {
+
+	es, err := Collect(args)
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to collect redirects")
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	contextLogger.Infof("collected %d redirects", len(es))
+
+	if serve != "" {
+		fmt.Printf("serving %d redirects on %s\n", len(es), serve)
+		if err := http.ListenAndServe(serve, Handler(es)); err != nil {
+			contextLogger.WithError(err).Error("redirect server stopped")
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		return
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			contextLogger.WithError(err).Error("unable to create output file")
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := Write(w, format, es); err != nil {
+		contextLogger.WithError(err).Error("unable to write redirects")
+		fmt.Println(err)
+		os.Exit(2)
+	}
+}
+
+// Collect gathers the redirects of the given projects (all of them when none are given) from the migration state
+func Collect(ps []string) ([]Entry, error) {
+	if len(ps) == 0 {
+		var err error
+		if ps, err = store.Projects(); err != nil {
+			return nil, err
+		}
+	}
+
+	var es []Entry
+	for _, p := range ps {
+		st, err := store.Load(p)
+		if err != nil {
+			return nil, err
+		}
+		if len(st.Issues) == 0 {
+			contextLogger.WithField("Project", p).Warn("no migrated issues found")
+		}
+		for _, i := range st.Issues {
+			if i.JiraKey == "" || i.WebURL == "" {
+				continue
+			}
+			es = append(es, Entry{Key: i.JiraKey, IID: i.IID, URL: i.WebURL})
+		}
+	}
+
+	sort.Slice(es, func(a, b int) bool { return keyLess(es[a].Key, es[b].Key) })
+	return es, nil
+}
+
+// ReadCSV reads redirects back from a map written in the csv format
+func ReadCSV(r io.Reader) ([]Entry, error) {
+	rs, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var es []Entry
+	for x, l := range rs {
+		if x == 0 && l[0] == "jira_key" {
+			continue
+		}
+		if len(l) != 3 {
+			return nil, fmt.Errorf("line %d: expected 3 columns, got %d", x+1, len(l))
+		}
+		iid, err := strconv.Atoi(l[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid gitlab iid %s", x+1, l[1])
+		}
+		es = append(es, Entry{Key: l[0], IID: iid, URL: l[2]})
+	}
+	return es, nil
+}
+
+// Path returns the gitlab project path (group/project) from the issue url
+func (e Entry) Path() string {
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return ""
+	}
+	p := strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(p, "/issues/"); i != -1 {
+		p = p[:i]
+	}
+	return strings.TrimSuffix(p, "/-")
+}
+
+// keyLess orders jira keys by project and then numerically, PRO-9 before PRO-10
+func keyLess(a, b string) bool {
+	pa, na := splitKey(a)
+	pb, nb := splitKey(b)
+	if pa != pb {
+		return pa < pb
+	}
+	return na < nb
+}
+
+func splitKey(k string) (string, int) {
+	i := strings.LastIndex(k, "-")
+	if i == -1 {
+		return k, 0
+	}
+	n, _ := strconv.Atoi(k[i+1:])
+	return k[:i], n
+}
+
+// Write renders the redirects in format f
+func Write(w io.Writer, f string, es []Entry) error {
+	switch f {
+	case "nginx":
+		// include in the http block and use: if ($jira_redirect) { return 301 $jira_redirect; }
+		fmt.Fprintln(w, "# jira redirects generated by pigmy")
+		fmt.Fprintln(w, "map $uri $jira_redirect {")
+		fmt.Fprintln(w, "    default \"\";")
+		for _, e := range es {
+			fmt.Fprintf(w, "    /browse/%s %s;\n", e.Key, e.URL)
+		}
+		_, err := fmt.Fprintln(w, "}")
+		return err
+	case "apache":
+		// use with: RewriteMap jira txt:<file> and RewriteRule ^/browse/(.+)$ ${jira:$1} [R=301,L]
+		fmt.Fprintln(w, "# jira redirects generated by pigmy")
+		for _, e := range es {
+			fmt.Fprintf(w, "%s %s\n", e.Key, e.URL)
+		}
+		return nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"jira_key", "gitlab_iid", "gitlab_url"})
+		for _, e := range es {
+			cw.Write([]string{e.Key, strconv.Itoa(e.IID), e.URL})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown redirect format %s", f)
+}
+
+// Handler redirects /browse/KEY (and the bare /KEY) to the gitlab issue
+func Handler(es []Entry) http.Handler {
+	m := make(map[string]string, len(es))
+	for _, e := range es {
+		m[e.Key] = e.URL
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/browse"), "/")
+		u, ok := m[strings.ToUpper(k)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, u, http.StatusMovedPermanently)
+	})
+}
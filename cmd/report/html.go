@@ -0,0 +1,98 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+)
+
+func writeCSV(w io.Writer, rows [][]string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteHTML renders the report as a single html page, styles and all
+func (s *Summary) WriteHTML(w io.Writer) error {
+	return page.Execute(w, s)
+}
+
+var page = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format("2006-01-02 15:04:05")
+	},
+	"duration": func(d time.Duration) string {
+		return d.Round(time.Second).String()
+	},
+	"rate": func(f float64) string {
+		return fmt.Sprintf("%.1f", f)
+	},
+	"items": func(title, detail string, is []Item) interface{} {
+		return struct {
+			Title, Detail string
+			Items         []Item
+		}{title, detail, is}
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>pigmy migration report {{.Run.ID}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.6em; }
+h2 { font-size: 1.2em; margin-top: 2em; border-bottom: 1px solid #ccc; }
+table { border-collapse: collapse; margin-top: .5em; }
+th, td { padding: .3em .8em; border: 1px solid #ddd; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+td.n { text-align: right; }
+.failed { color: #b00; }
+.none { color: #888; font-style: italic; }
+dl { display: grid; grid-template-columns: max-content auto; gap: .3em 1em; }
+dt { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Migration report</h1>
+<dl>
+<dt>Run</dt><dd>{{.Run.ID}}</dd>
+<dt>Command</dt><dd>{{.Run.Command}}</dd>
+<dt>Started</dt><dd>{{time .Run.Started}}</dd>
+<dt>Finished</dt><dd>{{time .Run.Finished}}</dd>
+<dt>Duration</dt><dd>{{duration .Duration}}</dd>
+<dt>Actions</dt><dd>{{.Entries}}</dd>
+<dt>Throughput</dt><dd>{{rate .Throughput}} issues per minute</dd>
+<dt>Failures</dt><dd{{if .Failures}} class="failed"{{end}}>{{len .Failures}}</dd>
+</dl>
+
+<h2>Objects</h2>
+{{if .Counts}}<table>
+<tr><th>Object</th><th>Action</th><th>OK</th><th>Failed</th><th>Average</th></tr>
+{{range .Counts}}<tr><td>{{.Kind}}</td><td>{{.Action}}</td><td class="n">{{.OK}}</td><td class="n{{if .Failed}} failed{{end}}">{{.Failed}}</td><td class="n">{{.AvgMS}} ms</td></tr>
+{{end}}</table>{{else}}<p class="none">nothing was done</p>{{end}}
+
+<h2>Contributions</h2>
+{{if .Contributions}}<table>
+<tr><th>Jira user</th><th>Issues</th><th>Notes</th></tr>
+{{range .Contributions}}<tr><td>{{.User}}</td><td class="n">{{.Issues}}</td><td class="n">{{.Notes}}</td></tr>
+{{end}}</table>{{else}}<p class="none">none</p>{{end}}
+
+{{template "items" (items "Failures" "Reason" .Failures)}}
+{{template "items" (items "Skipped" "Why" .Skipped)}}
+{{template "items" (items "Unmapped fields" "Fields" .Unmapped)}}
+</body>
+</html>
+{{define "items"}}<h2>{{.Title}}</h2>
+{{if .Items}}<table>
+<tr><th>Time</th><th>Object</th><th>Action</th><th>{{.Detail}}</th><th>Jira</th><th>GitLab</th></tr>
+{{range .Items}}<tr><td>{{time .Time}}</td><td>{{.Kind}} {{.Name}}</td><td>{{.Action}}</td><td>{{.Detail}}</td><td>{{if .JiraURL}}<a href="{{.JiraURL}}">jira</a>{{end}}</td><td>{{if .GitlabURL}}<a href="{{.GitlabURL}}">gitlab</a>{{end}}</td></tr>
+{{end}}</table>{{else}}<p class="none">none</p>{{end}}
+{{end}}`))
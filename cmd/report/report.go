@@ -0,0 +1,363 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/wianvos/pigmy/cmd/store"
+)
+
+var reportCMD = &cobra.Command{
+	Use:   "report <run-id>",
+	Short: "write a migration report of a run",
+	Run:   runReport,
+}
+
+var contextLogger = log.WithFields(log.Fields{"Command": "Report"})
+
+var format string
+var output string
+
+// Count sums up the outcome of an action on a kind of object
+type Count struct {
+	Kind   string
+	Action string
+	OK     int
+	Failed int
+	// AvgMS is the average duration of the action in milliseconds
+	AvgMS int64
+
+	total int64
+}
+
+// Contribution sums up what was migrated of a single jira user
+type Contribution struct {
+	User   string
+	Issues int
+	Notes  int
+}
+
+// Item is a single journal entry worth listing, with links to both sides
+type Item struct {
+	Time      time.Time
+	Kind      string
+	Action    string
+	Name      string
+	Detail    string
+	JiraURL   string
+	GitlabURL string
+}
+
+// Summary is everything the report tells about a run
+type Summary struct {
+	Run      *store.Run
+	Duration time.Duration
+	Entries  int
+	// Throughput is the number of issues migrated per minute
+	Throughput    float64
+	Counts        []*Count
+	Contributions []*Contribution
+	Failures      []Item
+	Skipped       []Item
+	Unmapped      []Item
+}
+
+//GetCommands grab and return commands in this package
+func GetCommands() *cobra.Command {
+
+	reportCMD.Flags().StringVar(&format, "format", "html", "report format: html, or csv (a directory of tables)")
+	reportCMD.Flags().StringVar(&output, "output", "", "file (html) or directory (csv) to write the report to, defaults to report-<run-id>")
+
+	return reportCMD
+}
+
+func runReport(cmd *cobra.Command, args []string) {
+
+	if len(args) != 1 {
+		contextLogger.Fatal("need the run to report on")
+		os.Exit(2)
+	}
+
+	r, err := store.LoadRun(args[0])
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to load run")
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	s, err := Summarize(r)
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to read the run journal")
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	o := output
+	switch format {
+	case "html":
+		if o == "" {
+			o = fmt.Sprintf("report-%s.html", r.ID)
+		}
+		err = writeFile(o, s.WriteHTML)
+	case "csv":
+		if o == "" {
+			o = fmt.Sprintf("report-%s", r.ID)
+		}
+		err = s.WriteCSV(o)
+	default:
+		err = fmt.Errorf("unknown report format %s", format)
+	}
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to write report")
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	fmt.Printf("report of run %s written to %s\n", r.ID, o)
+}
+
+func writeFile(f string, fn func(io.Writer) error) error {
+	fh, err := os.Create(f)
+	if err != nil {
+		return err
+	}
+	if err := fn(fh); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// Summarize reads the journal of run r and sums it up
+func Summarize(r *store.Run) (*Summary, error) {
+	es, err := r.Journal()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Summary{Run: r, Entries: len(es)}
+	l := links{stores: make(map[string]*store.Store)}
+
+	counts := make(map[string]*Count)
+	users := make(map[string]*Contribution)
+	issues := 0
+	end := r.Finished
+
+	for _, e := range es {
+		if e.Time.After(end) {
+			end = e.Time
+		}
+		// gitea issues and notes count the same as gitlab ones
+		kind := strings.TrimPrefix(e.Kind, store.GiteaPrefix)
+
+		k := e.Kind + "/" + e.Action
+		c, ok := counts[k]
+		if !ok {
+			c = &Count{Kind: e.Kind, Action: e.Action}
+			counts[k] = c
+		}
+		c.total += e.DurationMS
+		if e.Result == store.ResultFailed {
+			c.Failed++
+		} else {
+			c.OK++
+		}
+
+		it := Item{
+			Time:      e.Time,
+			Kind:      e.Kind,
+			Action:    e.Action,
+			Name:      name(e),
+			JiraURL:   l.jira(e),
+			GitlabURL: l.gitlab(e),
+		}
+
+		switch {
+		case e.Result == store.ResultFailed:
+			it.Detail = e.Error
+			s.Failures = append(s.Failures, it)
+			continue
+		case e.Action == store.ActionSkip:
+			it.Detail = "already in gitlab"
+			s.Skipped = append(s.Skipped, it)
+		}
+
+		if kind == store.ObjectIssue && (e.Action == store.ActionCreate || e.Action == store.ActionUpdate) {
+			issues++
+			if len(e.Unmapped) != 0 {
+				it.Detail = strings.Join(e.Unmapped, ", ")
+				s.Unmapped = append(s.Unmapped, it)
+			}
+		}
+
+		if e.Author != "" && e.Action == store.ActionCreate {
+			u, ok := users[e.Author]
+			if !ok {
+				u = &Contribution{User: e.Author}
+				users[e.Author] = u
+			}
+			switch kind {
+			case store.ObjectIssue:
+				u.Issues++
+			case store.ObjectNote:
+				u.Notes++
+			}
+		}
+	}
+
+	if !end.IsZero() {
+		s.Duration = end.Sub(r.Started)
+	}
+	if m := s.Duration.Minutes(); m > 0 {
+		s.Throughput = float64(issues) / m
+	}
+
+	for _, c := range counts {
+		if n := c.OK + c.Failed; n != 0 {
+			c.AvgMS = c.total / int64(n)
+		}
+		s.Counts = append(s.Counts, c)
+	}
+	sort.Slice(s.Counts, func(i, j int) bool {
+		if s.Counts[i].Kind != s.Counts[j].Kind {
+			return s.Counts[i].Kind < s.Counts[j].Kind
+		}
+		return s.Counts[i].Action < s.Counts[j].Action
+	})
+
+	for _, u := range users {
+		s.Contributions = append(s.Contributions, u)
+	}
+	sort.Slice(s.Contributions, func(i, j int) bool {
+		a, b := s.Contributions[i], s.Contributions[j]
+		if a.Issues+a.Notes != b.Issues+b.Notes {
+			return a.Issues+a.Notes > b.Issues+b.Notes
+		}
+		return a.User < b.User
+	})
+
+	return s, nil
+}
+
+// links works out where journal entries live in jira and gitlab
+type links struct {
+	stores map[string]*store.Store
+}
+
+func (l links) jira(e *store.Entry) string {
+	// issues that don't come from jira are known by their url
+	if strings.HasPrefix(e.JiraID, "http") {
+		return e.JiraID
+	}
+	if e.JiraKey == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/browse/%s", strings.TrimRight(viper.GetString("jiraURL"), "/"), e.JiraKey)
+}
+
+func (l links) gitlab(e *store.Entry) string {
+	if e.JiraProject == "" || e.JiraID == "" {
+		return ""
+	}
+	// what was created in gitea is in the gitea state
+	t := store.TargetGitlab
+	if strings.HasPrefix(e.Kind, store.GiteaPrefix) {
+		t = store.TargetGitea
+	}
+	s, ok := l.stores[t+"/"+e.JiraProject]
+	if !ok {
+		var err error
+		if s, err = store.LoadTarget(t, e.JiraProject); err != nil {
+			contextLogger.WithError(err).Errorf("unable to load the state of %s", e.JiraProject)
+		}
+		l.stores[t+"/"+e.JiraProject] = s
+	}
+	if s == nil {
+		return ""
+	}
+	m := s.Issue(e.JiraID)
+	if m == nil || m.WebURL == "" {
+		return ""
+	}
+	switch {
+	case e.Kind == store.ObjectNote && e.ID != 0:
+		return fmt.Sprintf("%s#note_%d", m.WebURL, e.ID)
+	case e.Kind == store.GiteaPrefix+store.ObjectNote && e.ID != 0:
+		return fmt.Sprintf("%s#issuecomment-%d", m.WebURL, e.ID)
+	}
+	return m.WebURL
+}
+
+// name gives the best human name for the object of e
+func name(e *store.Entry) string {
+	switch {
+	case e.JiraKey != "" && e.JiraComment != "":
+		return fmt.Sprintf("%s comment %s", e.JiraKey, e.JiraComment)
+	case e.JiraKey != "" && e.JiraAttachment != "":
+		return fmt.Sprintf("%s attachment %s", e.JiraKey, e.JiraAttachment)
+	case e.JiraKey != "":
+		return e.JiraKey
+	case e.Name != "":
+		return e.Name
+	case e.Group != "":
+		return fmt.Sprintf("%d in %s", e.ID, e.Group)
+	}
+	return fmt.Sprint(e.ID)
+}
+
+// tables returns the report as named tables, header row first
+func (s *Summary) tables() map[string][][]string {
+	t := make(map[string][][]string)
+
+	t["counts"] = [][]string{{"kind", "action", "ok", "failed", "avg_ms"}}
+	for _, c := range s.Counts {
+		t["counts"] = append(t["counts"], []string{c.Kind, c.Action, fmt.Sprint(c.OK), fmt.Sprint(c.Failed), fmt.Sprint(c.AvgMS)})
+	}
+
+	t["contributions"] = [][]string{{"user", "issues", "notes"}}
+	for _, u := range s.Contributions {
+		t["contributions"] = append(t["contributions"], []string{u.User, fmt.Sprint(u.Issues), fmt.Sprint(u.Notes)})
+	}
+
+	for n, is := range map[string][]Item{"failures": s.Failures, "skipped": s.Skipped, "unmapped": s.Unmapped} {
+		t[n] = [][]string{{"time", "kind", "action", "name", "detail", "jira_url", "gitlab_url"}}
+		for _, i := range is {
+			t[n] = append(t[n], []string{i.Time.Format(time.RFC3339), i.Kind, i.Action, i.Name, i.Detail, i.JiraURL, i.GitlabURL})
+		}
+	}
+
+	t["run"] = [][]string{
+		{"run", "command", "started", "finished", "duration_s", "entries", "issues_per_minute"},
+		{s.Run.ID, s.Run.Command, s.Run.Started.Format(time.RFC3339), finished(s.Run), fmt.Sprintf("%.0f", s.Duration.Seconds()), fmt.Sprint(s.Entries), fmt.Sprintf("%.1f", s.Throughput)},
+	}
+
+	return t
+}
+
+func finished(r *store.Run) string {
+	if r.Finished.IsZero() {
+		return ""
+	}
+	return r.Finished.Format(time.RFC3339)
+}
+
+// WriteCSV writes every table of the report as a csv file in directory d
+func (s *Summary) WriteCSV(d string) error {
+	if err := os.MkdirAll(d, 0770); err != nil {
+		return err
+	}
+	for n, rows := range s.tables() {
+		if err := writeFile(filepath.Join(d, n+".csv"), func(w io.Writer) error { return writeCSV(w, rows) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package retry
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/wianvos/pigmy/cmd/migrate"
+	"github.com/wianvos/pigmy/cmd/store"
+)
+
+var retryCMD = &cobra.Command{
+	Use:   "retry-failed <run-id>",
+	Short: "apply the issues, notes, attachements and write backs that failed in a run once more",
+	Run:   runRetry,
+}
+
+var contextLogger = log.WithFields(log.Fields{"Command": "Retry"})
+
+var dryRun bool
+
+// unit is what gets retried: a jira issue, in gitlab or written back to jira
+type unit struct {
+	Kind        string
+	JiraProject string
+	JiraID      string
+	JiraKey     string
+	Error       string
+}
+
+//GetCommands grab and return commands in this package
+func GetCommands() *cobra.Command {
+
+	retryCMD.Flags().BoolVar(&dryRun, "dry-run", false, "only show what would be retried")
+	migrate.AddWriteBackFlags(retryCMD)
+
+	return retryCMD
+}
+
+func runRetry(cmd *cobra.Command, args []string) {
+
+	if len(args) != 1 {
+		contextLogger.Fatal("need the run to retry the failures of")
+		os.Exit(2)
+	}
+
+	r, err := store.LoadRun(args[0])
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to load run")
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	es, err := r.Journal()
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to read the run journal")
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	us, other := failures(es)
+	for _, e := range other {
+		fmt.Printf("can't retry the %s of %s %s (%s), run the migration of %s again\n", e.Action, e.Kind, name(e), e.Error, e.JiraProject)
+	}
+	if len(us) == 0 {
+		fmt.Println("nothing to retry")
+		return
+	}
+
+	if dryRun {
+		for _, u := range us {
+			fmt.Printf("would retry %s %s: %s\n", u.Kind, u.JiraKey, u.Error)
+		}
+		fmt.Printf("%d failures would be retried\n", len(us))
+		return
+	}
+
+	if _, err := migrate.BeginRun("retry-failed " + r.ID); err != nil {
+		contextLogger.WithError(err).Error("unable to record the run")
+		fmt.Printf("unable to record the run: %s .. exiting\n", err)
+		os.Exit(2)
+	}
+
+	ps := make(map[string]*migrate.Project)
+	e := 0
+	for _, u := range us {
+		contextLogger := contextLogger.WithFields(log.Fields{"JiraIssue": u.JiraKey, "kind": u.Kind})
+
+		t := time.Now()
+		err := apply(ps, u)
+		if err != nil {
+			contextLogger.WithError(err).Error("retry failed")
+			fmt.Printf("%s %s: %s\n", u.Kind, u.JiraKey, err)
+			e++
+		} else {
+			fmt.Printf("%s %s: ok\n", u.Kind, u.JiraKey)
+		}
+
+		// the original journal learns about the retry, so the next retry-failed skips what got fixed
+		re := &store.Entry{
+			Time:       t,
+			Action:     store.ActionRetry,
+			Result:     store.ResultOK,
+			DurationMS: int64(time.Since(t) / time.Millisecond),
+			Object:     store.Object{Kind: u.Kind, JiraProject: u.JiraProject, JiraID: u.JiraID, JiraKey: u.JiraKey},
+		}
+		if err != nil {
+			re.Result, re.Error = store.ResultFailed, err.Error()
+		}
+		if err := r.Log(re); err != nil {
+			contextLogger.WithError(err).Error("unable to update the journal")
+		}
+	}
+
+	fmt.Printf("retried %d failures. %d errors encountered\n", len(us), e)
+	migrate.EndRun()
+	if e != 0 {
+		os.Exit(1)
+	}
+}
+
+// apply retries a single unit
+func apply(ps map[string]*migrate.Project, u unit) error {
+	p, ok := ps[u.JiraProject]
+	if !ok {
+		var err error
+		if p, err = migrate.LoadProject(u.JiraProject); err != nil {
+			return err
+		}
+		ps[u.JiraProject] = p
+	}
+
+	if u.Kind == store.ObjectJiraIssue {
+		return p.WriteBackIssue(u.JiraID)
+	}
+	// syncing the issue creates it, or brings it up to date and appends the missing notes and attachements
+	return p.SyncIssue(u.JiraID)
+}
+
+// failures works out what failed in a run and was not fixed by a retry since. failures on jira issues
+// come back as units, everything else (projects, users, memberships, labels) as the failed entries
+func failures(es []*store.Entry) ([]unit, []*store.Entry) {
+	us := make(map[string]*unit)
+	var order []string
+	var other []*store.Entry
+
+	for _, e := range es {
+		// retrying goes through gitlab, what failed in gitea takes a new migration
+		if e.JiraID == "" || strings.HasPrefix(e.Kind, store.GiteaPrefix) {
+			if e.Result == store.ResultFailed {
+				other = append(other, e)
+			}
+			continue
+		}
+
+		k := store.ObjectIssue
+		if e.Kind == store.ObjectJiraIssue {
+			k = store.ObjectJiraIssue
+		}
+		key := fmt.Sprintf("%s/%s/%s", k, e.JiraProject, e.JiraID)
+
+		switch {
+		case e.Action == store.ActionRetry && e.Result == store.ResultOK:
+			delete(us, key)
+		case e.Result == store.ResultFailed:
+			if _, ok := us[key]; !ok {
+				order = append(order, key)
+			}
+			us[key] = &unit{Kind: k, JiraProject: e.JiraProject, JiraID: e.JiraID, JiraKey: name(e), Error: e.Error}
+		}
+	}
+
+	var l []unit
+	seen := make(map[string]bool)
+	for _, k := range order {
+		if u, ok := us[k]; ok && !seen[k] {
+			l = append(l, *u)
+			seen[k] = true
+		}
+	}
+	// issues first, what gets written back to jira needs them
+	sort.SliceStable(l, func(i, j int) bool { return l[i].Kind == store.ObjectIssue && l[j].Kind != store.ObjectIssue })
+	return l, other
+}
+
+// name gives the best human name for the object of e
+func name(e *store.Entry) string {
+	switch {
+	case e.JiraKey != "":
+		return e.JiraKey
+	case e.JiraID != "":
+		return e.JiraID
+	case e.Name != "":
+		return e.Name
+	}
+	return fmt.Sprint(e.ID)
+}
@@ -0,0 +1,341 @@
+package rollback
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/wianvos/pigmy/cmd/store"
+	utils "github.com/wianvos/pigmy/cmd/utils"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// rollbackLabel marks the issues closed instead of deleted
+const rollbackLabel = "migration-rollback"
+
+var rollbackCMD = &cobra.Command{
+	Use:   "rollback [run-id]",
+	Short: "undo what a run created in gitlab, lists the runs when no run is given",
+	Run:   runRollback,
+}
+
+var contextLogger = log.WithFields(log.Fields{"Command": "Rollback"})
+
+var dryRun bool
+var closeIssues bool
+var deleteUsers bool
+
+//GetCommands grab and return commands in this package
+func GetCommands() *cobra.Command {
+
+	rollbackCMD.Flags().BoolVar(&dryRun, "dry-run", false, "only show what would be undone")
+	rollbackCMD.Flags().BoolVar(&closeIssues, "close", false, "close the issues and label them "+rollbackLabel+" instead of deleting them, users, groups, memberships and labels are kept")
+	rollbackCMD.Flags().BoolVar(&deleteUsers, "delete-users", false, "delete the users the run created too, unless a later run made them author or member of something")
+
+	return rollbackCMD
+}
+
+func runRollback(cmd *cobra.Command, args []string) {
+
+	if len(args) == 0 {
+		if err := listRuns(); err != nil {
+			contextLogger.WithError(err).Error("unable to list runs")
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		return
+	}
+
+	r, err := store.LoadRun(args[0])
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to load run")
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if r.Finished.IsZero() && !r.Abandoned() {
+		fmt.Printf("run %s has not finished yet\n", r.ID)
+		os.Exit(2)
+	}
+
+	objs, err := r.Created()
+	if err == nil {
+		var done map[string]bool
+		if done, err = r.RolledBackKeys(); err == nil {
+			err = rollback(r, objs, done)
+		}
+	}
+	if err != nil {
+		contextLogger.WithError(err).Error("rollback failed")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// rollback undoes objects objs of run r, newest first, skipping those already done
+func rollback(r *store.Run, objs []*store.Object, done map[string]bool) error {
+	contextLogger := contextLogger.WithField("run", r.ID)
+
+	// a project or group the run created takes everything in it along, no need to go one by one.
+	// the same goes for the notes on issues the run created, they go or stay with the issue
+	deleted := make(map[int]bool)
+	groups := make(map[string]bool)
+	issues := make(map[string]bool)
+	for _, o := range objs {
+		switch {
+		case o.Kind == store.ObjectProject && !closeIssues:
+			deleted[o.ProjectID] = true
+		case o.Kind == store.ObjectGroup && !closeIssues:
+			groups[o.Group] = true
+		case o.Kind == store.ObjectIssue:
+			issues[fmt.Sprintf("%d#%d", o.ProjectID, o.IssueIID)] = true
+		}
+	}
+
+	// deleting a user hands their contributions to the ghost user, so users later runs made use of stay
+	var inUse map[string]bool
+	if deleteUsers && !closeIssues {
+		var err error
+		if inUse, err = usedAfter(r); err != nil {
+			return err
+		}
+	}
+
+	stores := make(map[string]*store.Store)
+	n, e := 0, 0
+	for x := len(objs) - 1; x >= 0; x-- {
+		o := objs[x]
+		if done[o.Key()] {
+			continue
+		}
+		if o.Kind != store.ObjectProject && deleted[o.ProjectID] {
+			continue
+		}
+		if o.Kind == store.ObjectMember && groups[o.Group] {
+			continue
+		}
+		if o.Kind == store.ObjectNote && issues[fmt.Sprintf("%d#%d", o.ProjectID, o.IssueIID)] {
+			continue
+		}
+		if o.Kind == store.ObjectUser && (inUse[o.Name] || inUse[fmt.Sprintf("#%d", o.ID)]) {
+			fmt.Printf("keeping user %s, later runs made use of it\n", o.Name)
+			continue
+		}
+
+		a, f := plan(o)
+		if a == "" {
+			continue
+		}
+		if dryRun {
+			fmt.Printf("would %s\n", a)
+			n++
+			continue
+		}
+
+		if err := f(); err != nil {
+			contextLogger.WithError(err).Errorf("unable to %s", a)
+			fmt.Printf("unable to %s: %s\n", a, err)
+			e++
+			continue
+		}
+		fmt.Println(a)
+		n++
+
+		if err := r.RolledBack(o); err != nil {
+			return err
+		}
+		if err := forget(stores, o); err != nil {
+			contextLogger.WithError(err).Error("unable to update the migration state")
+		}
+	}
+
+	for _, s := range stores {
+		if err := s.Save(); err != nil {
+			return err
+		}
+	}
+
+	if dryRun {
+		fmt.Printf("%d objects would be rolled back\n", n)
+		return nil
+	}
+	fmt.Printf("%d objects rolled back. %d errors encountered\n", n, e)
+	if e != 0 {
+		return fmt.Errorf("rollback of run %s incomplete", r.ID)
+	}
+	return nil
+}
+
+// plan describes what rolling back o takes and returns the function doing it, nothing when o stays
+func plan(o *store.Object) (string, func() error) {
+	glc := utils.GetGitlabClient()
+
+	switch o.Kind {
+	case store.ObjectProject:
+		if closeIssues {
+			return "", nil
+		}
+		return fmt.Sprintf("delete project %s", o.Name), func() error {
+			return gone(glc.Projects.DeleteProject(o.ProjectID))
+		}
+	case store.ObjectIssue:
+		if closeIssues {
+			return fmt.Sprintf("close issue #%d in project %d", o.IssueIID, o.ProjectID), func() error {
+				return closeIssue(o.ProjectID, o.IssueIID)
+			}
+		}
+		return fmt.Sprintf("delete issue #%d in project %d", o.IssueIID, o.ProjectID), func() error {
+			return gone(glc.Issues.DeleteIssue(o.ProjectID, o.IssueIID))
+		}
+	case store.ObjectNote:
+		return fmt.Sprintf("delete note %d on issue #%d in project %d", o.ID, o.IssueIID, o.ProjectID), func() error {
+			return gone(glc.Notes.DeleteIssueNote(o.ProjectID, o.IssueIID, o.ID))
+		}
+	case store.ObjectLabel:
+		if closeIssues {
+			return "", nil
+		}
+		return fmt.Sprintf("delete label %s in project %d", o.Name, o.ProjectID), func() error {
+			return gone(glc.Labels.DeleteLabel(o.ProjectID, &gitlab.DeleteLabelOptions{Name: &o.Name}))
+		}
+	case store.ObjectMember:
+		if closeIssues {
+			return "", nil
+		}
+		if o.Group != "" {
+			return fmt.Sprintf("remove user %d from group %s", o.ID, o.Group), func() error {
+				return gone(glc.GroupMembers.RemoveGroupMember(o.Group, o.ID))
+			}
+		}
+		return fmt.Sprintf("remove user %d from project %d", o.ID, o.ProjectID), func() error {
+			return gone(glc.ProjectMembers.DeleteProjectMember(o.ProjectID, o.ID))
+		}
+	case store.ObjectGroup:
+		if closeIssues {
+			return "", nil
+		}
+		return fmt.Sprintf("delete group %s", o.Group), func() error {
+			return gone(glc.Groups.DeleteGroup(o.ID))
+		}
+	case store.ObjectUser:
+		if closeIssues || !deleteUsers {
+			return "", nil
+		}
+		return fmt.Sprintf("delete user %s", o.Name), func() error {
+			return gone(glc.Users.DeleteUser(o.ID))
+		}
+	}
+	return "", nil
+}
+
+// usedAfter returns the names and ids of the users that runs started after r made author or member of something
+func usedAfter(r *store.Run) (map[string]bool, error) {
+	rs, err := store.Runs()
+	if err != nil {
+		return nil, err
+	}
+
+	u := make(map[string]bool)
+	for _, lr := range rs {
+		if lr.ID == r.ID || !lr.Started.After(r.Started) {
+			continue
+		}
+		es, err := lr.Journal()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range es {
+			if e.Result != store.ResultOK {
+				continue
+			}
+			if e.Author != "" {
+				u[e.Author] = true
+			}
+			if e.Kind == store.ObjectMember {
+				u[fmt.Sprintf("#%d", e.ID)] = true
+			}
+		}
+	}
+	return u, nil
+}
+
+// closeIssue closes an issue and labels it as rolled back
+func closeIssue(pid, iid int) error {
+	glc := utils.GetGitlabClient()
+
+	i, resp, err := glc.Issues.GetIssue(pid, iid)
+	if err != nil {
+		return gone(resp, err)
+	}
+	ls := append(gitlab.Labels{}, i.Labels...)
+	ls = append(ls, rollbackLabel)
+	cs := "close"
+	_, _, err = glc.Issues.UpdateIssue(pid, iid, &gitlab.UpdateIssueOptions{StateEvent: &cs, Labels: ls})
+	return err
+}
+
+// gone treats whatever no longer exists as rolled back
+func gone(resp *gitlab.Response, err error) error {
+	if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// forget removes what was rolled back from the migration state, so the next run migrates it again
+func forget(stores map[string]*store.Store, o *store.Object) error {
+	if o.JiraProject == "" {
+		return nil
+	}
+	s, ok := stores[o.JiraProject]
+	if !ok {
+		var err error
+		if s, err = store.Load(o.JiraProject); err != nil {
+			return err
+		}
+		stores[o.JiraProject] = s
+	}
+
+	switch o.Kind {
+	case store.ObjectProject:
+		if s.GitlabPID == o.ProjectID {
+			s.Reset()
+		}
+	case store.ObjectIssue:
+		s.RemoveIssue(o.JiraID)
+	case store.ObjectNote:
+		if m := s.Issue(o.JiraID); m != nil {
+			delete(m.Comments, o.JiraComment)
+			delete(m.Attachments, o.JiraAttachment)
+		}
+	}
+	return nil
+}
+
+// listRuns prints the recorded runs
+func listRuns() error {
+	rs, err := store.Runs()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tCOMMAND\tSTARTED\tFINISHED\tCREATED")
+	for _, r := range rs {
+		objs, err := r.Created()
+		if err != nil {
+			return err
+		}
+		f := "-"
+		if !r.Finished.IsZero() {
+			f = r.Finished.Format(time.RFC3339)
+		} else if r.Abandoned() {
+			f = "abandoned"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", r.ID, r.Command, r.Started.Format(time.RFC3339), f, len(objs))
+	}
+	return w.Flush()
+}
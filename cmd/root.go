@@ -29,7 +29,14 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/wianvos/pigmy/cmd/git"
 	"github.com/wianvos/pigmy/cmd/migrate"
+	"github.com/wianvos/pigmy/cmd/redirects"
+	"github.com/wianvos/pigmy/cmd/report"
+	"github.com/wianvos/pigmy/cmd/retry"
+	"github.com/wianvos/pigmy/cmd/rollback"
+	"github.com/wianvos/pigmy/cmd/verify"
+	"github.com/wianvos/pigmy/cmd/webhook"
 	gitlab "github.com/xanzy/go-gitlab"
 )
 
@@ -62,6 +69,7 @@ var gitlabURL string
 var gitlabToken string
 var gitlabProjectID string
 var localTmpDir string
+var stateDir string
 var logLevel string
 var logFile string
 
@@ -106,8 +114,9 @@ func init() {
 	RootCmd.PersistentFlags().StringVar(&jiraProject, "jiraProject", "", "jira project to copy issues from")
 	RootCmd.PersistentFlags().StringVar(&gitlabURL, "gitlabURL", "", "gitlab server URL")
 	RootCmd.PersistentFlags().StringVar(&gitlabToken, "gitlabToken", "", "gitlab access token")
-	RootCmd.PersistentFlags().StringVar(&gitlabProjectID, "gitlabProjectID", "", "gitlab project id")
+	RootCmd.PersistentFlags().StringVar(&gitlabProjectID, "gitlabProjectID", "", "id or full path (namespace/project) of the gitlab project to migrate to")
 	RootCmd.PersistentFlags().StringVar(&localTmpDir, "localTmpDir", "./tmp", "temporary file dir")
+	RootCmd.PersistentFlags().StringVar(&stateDir, "stateDir", "./state", "directory holding the migration state")
 	RootCmd.PersistentFlags().BoolVar(&logToFile, "logToFile", true, "log to file?")
 	RootCmd.PersistentFlags().StringVar(&logLevel, "logLevel", "warning", "set pigmy loglevel")
 	RootCmd.PersistentFlags().StringVar(&logFile, "logFile", "./pigmy.log", "set pigmy logfile")
@@ -123,13 +132,24 @@ func init() {
 	viper.BindPFlag("logFile", RootCmd.PersistentFlags().Lookup("logFile"))
 	viper.BindPFlag("logToFile", RootCmd.PersistentFlags().Lookup("logToFile"))
 	viper.BindPFlag("localTmpDir", RootCmd.PersistentFlags().Lookup("localTmpDir"))
+	viper.BindPFlag("stateDir", RootCmd.PersistentFlags().Lookup("stateDir"))
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	// RootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
+	// authorship and notification flags apply to migrate, sync and retry-failed alike
+	migrate.AddRunFlags(RootCmd)
+
 	//add subcommand object to the root command
 	RootCmd.AddCommand(migrate.GetCommands())
+	RootCmd.AddCommand(webhook.GetCommands())
+	RootCmd.AddCommand(verify.GetCommands())
+	RootCmd.AddCommand(redirects.GetCommands())
+	RootCmd.AddCommand(git.GetCommands())
+	RootCmd.AddCommand(rollback.GetCommands())
+	RootCmd.AddCommand(retry.GetCommands())
+	RootCmd.AddCommand(report.GetCommands())
 
 }
 
@@ -187,6 +207,9 @@ func processConfig() {
 	if localTmpDir == "" && viper.IsSet("localTmpDir") {
 		localTmpDir = viper.GetString("localTmpDir")
 	}
+	if stateDir == "" && viper.IsSet("stateDir") {
+		stateDir = viper.GetString("stateDir")
+	}
 
 }
 func initializeLogging(cmd *cobra.Command, args []string) {
@@ -0,0 +1,353 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Run records a single pigmy run, so whatever it leaves behind can be cleaned up should it not finish
+type Run struct {
+	ID       string    `json:"id"`
+	Command  string    `json:"command"`
+	Host     string    `json:"host"`
+	PID      int       `json:"pid"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitempty"`
+	Tokens   []*Token  `json:"tokens,omitempty"`
+	// Notifications holds the notification settings the run changed, to be restored when it ends
+	Notifications []*Notification `json:"notifications,omitempty"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// Token is an impersonation token minted during a run
+type Token struct {
+	Username string `json:"username"`
+	UserID   int    `json:"userID"`
+	TokenID  int    `json:"tokenID"`
+	Revoked  bool   `json:"revoked,omitempty"`
+}
+
+// Notification is the original notification level of a user, globally or for a single project
+type Notification struct {
+	Username  string `json:"username"`
+	UserID    int    `json:"userID"`
+	ProjectID int    `json:"projectID,omitempty"`
+	Level     string `json:"level"`
+	Restored  bool   `json:"restored,omitempty"`
+}
+
+// kinds of objects a run creates in gitlab
+const (
+	ObjectProject = "project"
+	ObjectIssue   = "issue"
+	ObjectNote    = "note"
+	ObjectLabel   = "label"
+	ObjectMember  = "member"
+	ObjectUser    = "user"
+	ObjectGroup   = "group"
+	// ObjectJiraIssue is the jira side of an issue, written back to
+	ObjectJiraIssue = "jira-issue"
+	// ObjectMilestone only exists in gitea
+	ObjectMilestone = "milestone"
+)
+
+// GiteaPrefix prefixes the kinds of objects created in gitea instead of gitlab
+const GiteaPrefix = "gitea-"
+
+// journal actions and results
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionSkip      = "skip"
+	ActionClose     = "close"
+	ActionWriteBack = "writeback"
+	ActionRetry     = "retry"
+	// ActionFetch is reading an issue from the source, it fails before anything is created
+	ActionFetch = "fetch"
+
+	ResultOK     = "ok"
+	ResultFailed = "failed"
+)
+
+// Object is something in gitlab (or jira) a run acted on
+type Object struct {
+	Kind      string `json:"kind"`
+	ProjectID int    `json:"projectID,omitempty"`
+	Group     string `json:"group,omitempty"`
+	IssueIID  int    `json:"issueIID,omitempty"`
+	// ID of the note, label or user (members: the user)
+	ID   int    `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	// where it came from in jira
+	JiraProject    string `json:"jiraProject,omitempty"`
+	JiraID         string `json:"jiraID,omitempty"`
+	JiraKey        string `json:"jiraKey,omitempty"`
+	JiraComment    string `json:"jiraComment,omitempty"`
+	JiraAttachment string `json:"jiraAttachment,omitempty"`
+	// Author is the jira user who wrote it
+	Author string `json:"author,omitempty"`
+	// Unmapped lists the jira fields that did not make it to gitlab
+	Unmapped []string `json:"unmapped,omitempty"`
+}
+
+// Key identifies the object within a run
+func (o *Object) Key() string {
+	return fmt.Sprintf("%s/%d/%s/%d/%d/%s", o.Kind, o.ProjectID, o.Group, o.IssueIID, o.ID, o.Name)
+}
+
+// RunDir returns the directory holding the run files
+func RunDir() string {
+	return filepath.Join(Dir(), "runs")
+}
+
+// NewRun starts the record of a run of command c
+func NewRun(c string) (*Run, error) {
+	h, _ := os.Hostname()
+	t := time.Now()
+	r := &Run{
+		ID:      fmt.Sprintf("%s-%d", t.UTC().Format("20060102T150405"), os.Getpid()),
+		Command: c,
+		Host:    h,
+		PID:     os.Getpid(),
+		Started: t,
+	}
+	r.path = filepath.Join(RunDir(), r.ID+".json")
+	return r, r.Save()
+}
+
+// LoadRun reads the record of run id
+func LoadRun(id string) (*Run, error) {
+	r := &Run{path: filepath.Join(RunDir(), id+".json")}
+
+	b, err := ioutil.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("unknown run %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("unable to parse run file %s: %s", r.path, err)
+	}
+	return r, nil
+}
+
+// Runs returns every recorded run, oldest first
+func Runs() ([]*Run, error) {
+	fl, err := filepath.Glob(filepath.Join(RunDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []*Run
+	for _, f := range fl {
+		r, err := LoadRun(strings.TrimSuffix(filepath.Base(f), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Started.Before(rs[j].Started) })
+	return rs, nil
+}
+
+// Save writes the run to disk, the same way a store is saved
+func (r *Run) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.save()
+}
+
+func (r *Run) save() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0770); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(r, "", " ")
+	if err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// AddToken records a minted impersonation token and saves the run straight away
+func (r *Run) AddToken(t *Token) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Tokens = append(r.Tokens, t)
+	return r.save()
+}
+
+// AddNotification records an original notification level and saves the run straight away.
+// it returns false when the run changed that setting before, the first recorded level is the original
+func (r *Run) AddNotification(n *Notification) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, o := range r.Notifications {
+		if o.UserID == n.UserID && o.ProjectID == n.ProjectID {
+			return false, nil
+		}
+	}
+	r.Notifications = append(r.Notifications, n)
+	return true, r.save()
+}
+
+// Entry is a single action of a run on a single object, as written to the run journal
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Run        string    `json:"run"`
+	Action     string    `json:"action"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"durationMs"`
+	Object
+}
+
+// Log appends e to the run journal
+func (r *Run) Log(e *Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e.Run = r.ID
+	return appendLine(r.JournalPath(), e)
+}
+
+// JournalPath returns the location of the run journal, newline delimited json
+func (r *Run) JournalPath() string {
+	return r.logPath("journal")
+}
+
+// Journal reads back the run journal, in the order it was written
+func (r *Run) Journal() ([]*Entry, error) {
+	var es []*Entry
+	err := readLines(r.JournalPath(), func(b []byte) error {
+		e := &Entry{}
+		if err := json.Unmarshal(b, e); err != nil {
+			return err
+		}
+		es = append(es, e)
+		return nil
+	})
+	return es, err
+}
+
+// Created returns the objects the run created in gitlab, in the order they were created
+func (r *Run) Created() ([]*Object, error) {
+	es, err := r.Journal()
+	if err != nil {
+		return nil, err
+	}
+
+	var objs []*Object
+	for _, e := range es {
+		if e.Action == ActionCreate && e.Result == ResultOK && e.Kind != ObjectJiraIssue {
+			o := e.Object
+			objs = append(objs, &o)
+		}
+	}
+	return objs, nil
+}
+
+// RolledBack records that o was rolled back
+func (r *Run) RolledBack(o *Object) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return appendLine(r.logPath("rollback"), o)
+}
+
+// RolledBackKeys returns the keys of the objects rolled back so far
+func (r *Run) RolledBackKeys() (map[string]bool, error) {
+	ks := make(map[string]bool)
+	err := readLines(r.logPath("rollback"), func(b []byte) error {
+		o := &Object{}
+		if err := json.Unmarshal(b, o); err != nil {
+			return err
+		}
+		ks[o.Key()] = true
+		return nil
+	})
+	return ks, err
+}
+
+// logPath returns the location of log n of the run, logs are appended to rather than rewritten
+func (r *Run) logPath(n string) string {
+	return filepath.Join(RunDir(), fmt.Sprintf("%s.%s.ndjson", r.ID, n))
+}
+
+func appendLine(f string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f), 0770); err != nil {
+		return err
+	}
+	fh, err := os.OpenFile(f, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fh.Write(append(b, '\n')); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// readLines calls fn for every line of f, a missing file has no lines
+func readLines(f string, fn func([]byte) error) error {
+	fh, err := os.Open(f)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	sc := bufio.NewScanner(fh)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		if err := fn(sc.Bytes()); err != nil {
+			return fmt.Errorf("unable to parse %s: %s", f, err)
+		}
+	}
+	return sc.Err()
+}
+
+// Abandoned tells if the run never finished and its process is gone.
+// runs started on another host can't be checked and are never considered abandoned
+func (r *Run) Abandoned() bool {
+	if !r.Finished.IsZero() {
+		return false
+	}
+	if h, _ := os.Hostname(); h != r.Host {
+		return false
+	}
+	if r.PID == os.Getpid() {
+		return false
+	}
+	return syscall.Kill(r.PID, 0) == syscall.ESRCH
+}
@@ -0,0 +1,216 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// kinds of targets migration state is kept for. every kind has a store of its own, so the state of
+// one never gets mistaken for that of another
+const (
+	TargetGitlab  = "gitlab"
+	TargetGitea   = "gitea"
+	TargetArchive = "archive"
+)
+
+// Store keeps track of everything pigmy migrated from a single jira project into gitlab.
+// it is persisted as a json file in the state directory so subsequent runs (and other commands) can find it back.
+// projects migrated to gitea keep the repository and its issue numbers in the gitlab fields
+type Store struct {
+	Project    string            `json:"project"`
+	Target     string            `json:"target,omitempty"`
+	GitlabPID  int               `json:"gitlabPID"`
+	GitlabPath string            `json:"gitlabPath"`
+	LastRun    time.Time         `json:"lastRun"`
+	Issues     map[string]*Issue `json:"issues"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// Issue maps a jira issue onto the gitlab issue it was migrated to
+type Issue struct {
+	JiraID      string         `json:"jiraID"`
+	JiraKey     string         `json:"jiraKey"`
+	IID         int            `json:"iid"`
+	WebURL      string         `json:"webURL"`
+	Comments    map[string]int `json:"comments"`
+	Attachments map[string]int `json:"attachments"`
+	Updated     time.Time      `json:"updated"`
+	WriteBack   *WriteBack     `json:"writeBack,omitempty"`
+	PendingRefs bool           `json:"pendingRefs,omitempty"`
+}
+
+// WriteBack records what was written back to the jira issue, so it is only done once
+type WriteBack struct {
+	CommentID    string `json:"commentID,omitempty"`
+	RemoteLink   bool   `json:"remoteLink,omitempty"`
+	Label        string `json:"label,omitempty"`
+	Transitioned string `json:"transitioned,omitempty"`
+	// Updated is when jira last updated the issue, as of the write back. the write back itself
+	// updates it, that is no reason to bring the gitlab issue up to date
+	Updated time.Time `json:"updated,omitempty"`
+}
+
+// Dir returns the directory holding the state files
+func Dir() string {
+	d := viper.GetString("stateDir")
+	if d == "" {
+		d = "./state"
+	}
+	return d
+}
+
+// Path returns the location of the gitlab state file for jira project p
+func Path(p string) string {
+	return TargetPath(TargetGitlab, p)
+}
+
+// TargetPath returns the location of the state file for jira project p migrated to a target of kind t.
+// gitlab state lives in the state directory itself, other kinds in a directory of their own
+func TargetPath(t, p string) string {
+	if t == TargetGitlab {
+		return filepath.Join(Dir(), fmt.Sprintf("%s.json", p))
+	}
+	return filepath.Join(Dir(), t, fmt.Sprintf("%s.json", p))
+}
+
+// Projects lists the jira projects there is gitlab migration state for
+func Projects() ([]string, error) {
+	fl, err := filepath.Glob(filepath.Join(Dir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var ps []string
+	for _, f := range fl {
+		ps = append(ps, strings.TrimSuffix(filepath.Base(f), ".json"))
+	}
+	return ps, nil
+}
+
+// Load reads the gitlab store for jira project p, returning an empty store if nothing was migrated yet
+func Load(p string) (*Store, error) {
+	return LoadTarget(TargetGitlab, p)
+}
+
+// LoadTarget reads the store for jira project p migrated to a target of kind t, returning an empty store
+// if nothing was migrated yet. state recorded for another kind of target is refused
+func LoadTarget(t, p string) (*Store, error) {
+	s := &Store{
+		Project: p,
+		Target:  t,
+		Issues:  make(map[string]*Issue),
+		path:    TargetPath(t, p),
+	}
+
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		log.Debugf("no state found for project %s, starting fresh", p)
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("unable to parse state file %s: %s", s.path, err)
+	}
+	// state from before targets were recorded is gitlab's
+	if s.Target == "" {
+		s.Target = TargetGitlab
+	}
+	if s.Target != t {
+		return nil, fmt.Errorf("the state in %s belongs to a %s target, not %s", s.path, s.Target, t)
+	}
+	if s.Issues == nil {
+		s.Issues = make(map[string]*Issue)
+	}
+
+	return s, nil
+}
+
+// Save writes the store to disk. the file is written next to the original and renamed so a crash never leaves a half written state
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0770); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(s, "", " ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Issue returns the mapping for jira issue id, nil if it was never migrated
+func (s *Store) Issue(id string) *Issue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.Issues[id]
+}
+
+// IssueByKey returns the mapping for the jira issue with key k, nil if it was never migrated
+func (s *Store) IssueByKey(k string) *Issue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, i := range s.Issues {
+		if i.JiraKey == k {
+			return i
+		}
+	}
+	return nil
+}
+
+// SetIssue records (or replaces) the mapping for a jira issue
+func (s *Store) SetIssue(i *Issue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i.Comments == nil {
+		i.Comments = make(map[string]int)
+	}
+	if i.Attachments == nil {
+		i.Attachments = make(map[string]int)
+	}
+	i.Updated = time.Now()
+	s.Issues[i.JiraID] = i
+}
+
+// RemoveIssue forgets the mapping for jira issue id
+func (s *Store) RemoveIssue(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.Issues, id)
+}
+
+// Reset forgets everything migrated, for when the gitlab project itself is gone
+func (s *Store) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.GitlabPID = 0
+	s.GitlabPath = ""
+	s.LastRun = time.Time{}
+	s.Issues = make(map[string]*Issue)
+}
@@ -0,0 +1,307 @@
+package verify
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	jira "github.com/wianvos/go-jira"
+	"github.com/wianvos/pigmy/cmd/migrate"
+	"github.com/wianvos/pigmy/cmd/store"
+	utils "github.com/wianvos/pigmy/cmd/utils"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+var jqlQuery string
+var format string
+var output string
+var skipSizes bool
+
+// uploadLink matches the upload links pigmy puts in attachement notes
+var uploadLink = regexp.MustCompile(`\]\((/uploads/[^)]+)\)`)
+
+//create the command and add it to the verifyCMD objects
+func addProject() {
+	cmd := &cobra.Command{
+		Use:   "project <name>",
+		Short: "compare a migrated jira project with its gitlab project field by field",
+		Run:   runProject,
+	}
+
+	cmd.Flags().StringVar(&jqlQuery, "jql", "", "jira JQL query selecting the issues to verify (defaults to the entire project)")
+	cmd.Flags().StringVar(&format, "format", "text", "report format: text, json or junit")
+	cmd.Flags().StringVar(&output, "output", "", "write the report to this file instead of stdout")
+	cmd.Flags().BoolVar(&skipSizes, "skip-sizes", false, "don't download attachement headers to compare file sizes")
+
+	verifyCMD.AddCommand(cmd)
+}
+
+func runProject(cmd *cobra.Command, args []string) {
+
+	contextLogger = contextLogger.WithFields(log.Fields{"subcommand": "Project"})
+	//check if we received an argument
+	if len(args) != 1 {
+		contextLogger.Fatal("need a project name to verify")
+		os.Exit(2)
+	}
+	name := args[0]
+	contextLogger = contextLogger.WithFields(log.Fields{"Project": name})
+
+	st, err := store.Load(name)
+	if err != nil || st.GitlabPID == 0 {
+		contextLogger.WithError(err).Error("no migration state found")
+		fmt.Printf("no migration state found for project %s, was it migrated ?\n", name)
+		os.Exit(2)
+	}
+
+	r, err := verifyProject(name, st)
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to verify project")
+		fmt.Printf("unable to verify project: %s\n", err)
+		os.Exit(2)
+	}
+
+	if err := writeReport(r); err != nil {
+		contextLogger.WithError(err).Error("unable to write report")
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	if r.Failed() {
+		os.Exit(1)
+	}
+}
+
+// writeReport writes the report to stdout or the output file
+func writeReport(r *Report) error {
+	if output == "" {
+		return r.Write(os.Stdout, format)
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := r.Write(f, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// verifyProject compares every selected jira issue with the gitlab issue the store maps it to
+func verifyProject(name string, st *store.Store) (*Report, error) {
+	jql := jqlQuery
+	if jql == "" {
+		jql = fmt.Sprintf("project = \"%s\"", name)
+	}
+
+	fmt.Println("collecting jira issues")
+	jis, err := migrate.SearchIssues(jql)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("collecting gitlab issues")
+	gis, err := listIssues(st.GitlabPID)
+	if err != nil {
+		return nil, err
+	}
+
+	glc := utils.GetGitlabClient()
+	gp, _, err := glc.Projects.GetProject(st.GitlabPID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// the selection may be a part of what was migrated and the gitlab project may hold issues of its own,
+	// so the issues the state maps are what should be found in gitlab
+	mapped, found := 0, 0
+	for _, m := range st.Issues {
+		mapped = mapped + 1
+		if _, ok := gis[m.IID]; ok {
+			found = found + 1
+		}
+	}
+
+	r := &Report{Project: name}
+	r.compare("", 0, "issue count", strconv.Itoa(mapped), strconv.Itoa(found))
+
+	for _, ji := range jis {
+		r.Checked = r.Checked + 1
+		r.keys = append(r.keys, ji.Key)
+
+		m := st.Issue(ji.ID)
+		if m == nil {
+			r.add(ji.Key, 0, "migrated", "mapped to a gitlab issue", "not in the migration state")
+			continue
+		}
+		gi, ok := gis[m.IID]
+		if !ok {
+			r.add(ji.Key, m.IID, "migrated", fmt.Sprintf("gitlab issue #%d", m.IID), "not found in gitlab")
+			continue
+		}
+
+		if err := verifyIssue(r, ji, gi, gp); err != nil {
+			contextLogger.WithError(err).WithField("JiraIssue", ji.Key).Error("unable to verify issue")
+			r.add(ji.Key, gi.IID, "verification", "completed", err.Error())
+		}
+	}
+
+	return r, nil
+}
+
+// verifyIssue compares a single jira issue with its gitlab counterpart
+func verifyIssue(r *Report, ji jira.Issue, gi *gitlab.Issue, gp *gitlab.Project) error {
+	// what the migration makes of the jira issue is what we expect to find in gitlab
+	e := migrate.NewIssue(&ji)
+	k := ji.Key
+
+	r.compare(k, gi.IID, "title", e.Title, gi.Title)
+
+	es := "opened"
+	if e.Status != "Open" {
+		es = "closed"
+	}
+	r.compare(k, gi.IID, "state", es, gi.State)
+
+	if e.Assignee != "" {
+		var a []string
+		for _, ga := range gi.Assignees {
+			a = append(a, ga.Username)
+		}
+		if !contains(a, e.Assignee) {
+			r.add(k, gi.IID, "assignee", e.Assignee, strings.Join(a, ","))
+		}
+	}
+
+	r.compare(k, gi.IID, "labels", sortedSet(e.Labels), sortedSet(gi.Labels))
+
+	notes, err := listNotes(gi.ProjectID, gi.IID)
+	if err != nil {
+		return err
+	}
+
+	// attachements live in notes of their own, everything else a user wrote is a comment
+	var comments int
+	var uploads []string
+	for _, n := range notes {
+		if n.System {
+			continue
+		}
+		if l := uploadLink.FindAllStringSubmatch(n.Body, -1); len(l) != 0 {
+			for _, u := range l {
+				uploads = append(uploads, u[1])
+			}
+			continue
+		}
+		comments = comments + 1
+	}
+
+	r.compare(k, gi.IID, "comment count", strconv.Itoa(len(e.Comments)), strconv.Itoa(comments))
+	r.compare(k, gi.IID, "attachement count", strconv.Itoa(len(ji.Fields.Attachments)), strconv.Itoa(len(uploads)))
+
+	if skipSizes || len(ji.Fields.Attachments) != len(uploads) {
+		return nil
+	}
+
+	var ea, gs []int
+	for _, a := range ji.Fields.Attachments {
+		ea = append(ea, a.Size)
+	}
+	for _, u := range uploads {
+		s, err := uploadSize(gp.ID, u)
+		if err != nil {
+			r.add(k, gi.IID, "attachement size", u, err.Error())
+			return nil
+		}
+		gs = append(gs, s)
+	}
+	sort.Ints(ea)
+	sort.Ints(gs)
+	r.compare(k, gi.IID, "attachement sizes", fmt.Sprint(ea), fmt.Sprint(gs))
+
+	return nil
+}
+
+// listIssues retrieves every issue of gitlab project pid, keyed by iid
+func listIssues(pid int) (map[int]*gitlab.Issue, error) {
+	glc := utils.GetGitlabClient()
+
+	is := make(map[int]*gitlab.Issue)
+	o := &gitlab.ListProjectIssuesOptions{ListOptions: gitlab.ListOptions{PerPage: 100, Page: 1}}
+	for {
+		l, resp, err := glc.Issues.ListProjectIssues(pid, o)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range l {
+			is[i.IID] = i
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		o.Page = resp.NextPage
+	}
+	return is, nil
+}
+
+// listNotes retrieves every note on gitlab issue iid
+func listNotes(pid, iid int) ([]*gitlab.Note, error) {
+	glc := utils.GetGitlabClient()
+
+	var ns []*gitlab.Note
+	o := &gitlab.ListIssueNotesOptions{ListOptions: gitlab.ListOptions{PerPage: 100, Page: 1}}
+	for {
+		l, resp, err := glc.Notes.ListIssueNotes(pid, iid, o)
+		if err != nil {
+			return nil, err
+		}
+		ns = append(ns, l...)
+		if resp.NextPage == 0 {
+			break
+		}
+		o.Page = resp.NextPage
+	}
+	return ns, nil
+}
+
+// uploadSize asks gitlab for the size of upload u of project pid without downloading it.
+// the project uploads api is used, the web route u points at doesn't take tokens for private projects
+func uploadSize(pid int, u string) (int, error) {
+	glc := utils.GetGitlabClient()
+
+	req, err := glc.NewRequest(http.MethodHead, fmt.Sprintf("projects/%d%s", pid, u), nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := glc.Do(req, nil)
+	if err != nil {
+		return 0, fmt.Errorf("unable to retrieve upload: %s", err)
+	}
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("gitlab did not report a size")
+	}
+	return int(resp.ContentLength), nil
+}
+
+func sortedSet(l []string) string {
+	s := append([]string{}, l...)
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func contains(l []string, s string) bool {
+	for _, x := range l {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package verify
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Discrepancy is a single difference found between a jira issue and its gitlab counterpart
+type Discrepancy struct {
+	JiraKey  string `json:"jiraKey,omitempty"`
+	IID      int    `json:"iid,omitempty"`
+	Field    string `json:"field"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// Report holds the outcome of a verification run
+type Report struct {
+	Project       string        `json:"project"`
+	Checked       int           `json:"checked"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+
+	keys []string
+}
+
+func (r *Report) add(key string, iid int, field, expected, actual string) {
+	r.Discrepancies = append(r.Discrepancies, Discrepancy{
+		JiraKey:  key,
+		IID:      iid,
+		Field:    field,
+		Expected: expected,
+		Actual:   actual,
+	})
+}
+
+// compare records a discrepancy when expected and actual differ
+func (r *Report) compare(key string, iid int, field, expected, actual string) {
+	if expected != actual {
+		r.add(key, iid, field, expected, actual)
+	}
+}
+
+// Failed tells if any discrepancies were found
+func (r *Report) Failed() bool {
+	return len(r.Discrepancies) != 0
+}
+
+// discrepancies returns the discrepancies found for jira issue key
+func (r *Report) discrepancies(key string) []Discrepancy {
+	var d []Discrepancy
+	for _, x := range r.Discrepancies {
+		if x.JiraKey == key {
+			d = append(d, x)
+		}
+	}
+	return d
+}
+
+// Write renders the report in format f (text, json or junit)
+func (r *Report) Write(w io.Writer, f string) error {
+	switch f {
+	case "text":
+		return r.writeText(w)
+	case "json":
+		return r.writeJSON(w)
+	case "junit":
+		return r.writeJUnit(w)
+	}
+	return fmt.Errorf("unknown report format %s", f)
+}
+
+func (r *Report) writeText(w io.Writer) error {
+	fmt.Fprintf(w, "verified %d issues of project %s: %d discrepancies\n", r.Checked, r.Project, len(r.Discrepancies))
+	for _, d := range r.Discrepancies {
+		k := d.JiraKey
+		if k == "" {
+			k = r.Project
+		}
+		if d.IID != 0 {
+			k = fmt.Sprintf("%s (#%d)", k, d.IID)
+		}
+		fmt.Fprintf(w, "%s %s: expected %q, got %q\n", k, d.Field, d.Expected, d.Actual)
+	}
+	return nil
+}
+
+func (r *Report) writeJSON(w io.Writer) error {
+	b, err := json.MarshalIndent(r, "", " ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Classname string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit renders one testcase for the project wide checks and one per verified issue
+func (r *Report) writeJUnit(w io.Writer) error {
+	s := junitSuite{Name: fmt.Sprintf("pigmy verify %s", r.Project)}
+
+	for _, k := range append([]string{""}, r.keys...) {
+		c := junitCase{Classname: r.Project, Name: k}
+		if k == "" {
+			c.Name = "project"
+		}
+
+		if d := r.discrepancies(k); len(d) != 0 {
+			var t []string
+			for _, x := range d {
+				t = append(t, fmt.Sprintf("%s: expected %q, got %q", x.Field, x.Expected, x.Actual))
+			}
+			c.Failure = &junitFailure{
+				Message: fmt.Sprintf("%d discrepancies", len(d)),
+				Text:    strings.Join(t, "\n"),
+			}
+			s.Failures = s.Failures + 1
+		}
+		s.Cases = append(s.Cases, c)
+	}
+	s.Tests = len(s.Cases)
+
+	b, err := xml.MarshalIndent(s, "", " ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, b)
+	return err
+}
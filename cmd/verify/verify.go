@@ -0,0 +1,21 @@
+package verify
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var verifyCMD = &cobra.Command{
+	Use:   "verify",
+	Short: "verify a migration by comparing jira and gitlab",
+}
+
+var contextLogger = log.WithFields(log.Fields{"Command": "Verify"})
+
+//GetCommands grab and return commands in this package
+func GetCommands() *cobra.Command {
+
+	//collect the commands in the package
+	addProject()
+	return verifyCMD
+}
@@ -0,0 +1,137 @@
+package webhook
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/wianvos/pigmy/cmd/migrate"
+)
+
+// applier applies translated jira events to the migration target
+type applier interface {
+	SyncIssue(project, issueID string) error
+	DeleteIssue(project, issueID string) error
+	SyncComment(project, issueID, commentID, body string) error
+}
+
+// dispatcher routes webhook payloads to an applier
+type dispatcher struct {
+	a applier
+}
+
+// Handle applies a single raw webhook payload
+func (d *dispatcher) Handle(b []byte) error {
+	e, err := ParseEvent(b)
+	if err != nil {
+		// retrying won't make a broken payload any better
+		contextLogger.WithError(err).Error("dropping unparseable event")
+		return nil
+	}
+
+	pk := e.Project()
+	contextLogger := contextLogger.WithFields(log.Fields{"event": e.WebhookEvent, "Project": pk, "JiraIssue": e.Issue.Key})
+
+	if !syncProject(pk) {
+		contextLogger.Debug("project not selected for sync, ignoring event")
+		return nil
+	}
+
+	contextLogger.Info("applying event")
+
+	switch e.WebhookEvent {
+	case issueCreated, issueUpdated, commentCreated:
+		return d.a.SyncIssue(pk, e.Issue.ID)
+	case issueDeleted:
+		return d.a.DeleteIssue(pk, e.Issue.ID)
+	case commentUpdated:
+		if e.Comment == nil {
+			return d.a.SyncIssue(pk, e.Issue.ID)
+		}
+		return d.a.SyncComment(pk, e.Issue.ID, e.Comment.ID, e.Comment.Body)
+	}
+
+	contextLogger.Debug("event type not supported, ignoring event")
+	return nil
+}
+
+func syncProject(pk string) bool {
+	if len(projects) == 0 {
+		return true
+	}
+	for _, p := range projects {
+		if p == pk {
+			return true
+		}
+	}
+	return false
+}
+
+// gitlabApplier applies events to the gitlab projects pigmy migrated to
+type gitlabApplier struct {
+	projects map[string]*migrate.Project
+}
+
+func newGitlabApplier() *gitlabApplier {
+	return &gitlabApplier{projects: make(map[string]*migrate.Project)}
+}
+
+// project loads the migrated project pk, nil when it was never migrated
+func (g *gitlabApplier) project(pk string) (*migrate.Project, error) {
+	if p, ok := g.projects[pk]; ok {
+		return p, nil
+	}
+
+	p, err := migrate.LoadProject(pk)
+	if err == migrate.ErrNotMigrated {
+		contextLogger.WithField("Project", pk).Info("project was never migrated, ignoring its events")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	g.projects[pk] = p
+	return p, nil
+}
+
+func (g *gitlabApplier) SyncIssue(pk, id string) error {
+	p, err := g.project(pk)
+	if p == nil {
+		return err
+	}
+	return p.SyncIssue(id)
+}
+
+func (g *gitlabApplier) DeleteIssue(pk, id string) error {
+	p, err := g.project(pk)
+	if p == nil {
+		return err
+	}
+	return p.DeleteIssue(id)
+}
+
+func (g *gitlabApplier) SyncComment(pk, id, cid, body string) error {
+	p, err := g.project(pk)
+	if p == nil {
+		return err
+	}
+	return p.SyncComment(id, cid, body)
+}
+
+// printApplier only reports what would be applied
+type printApplier struct{}
+
+func (printApplier) SyncIssue(pk, id string) error {
+	fmt.Printf("%s: sync issue %s\n", pk, id)
+	return nil
+}
+
+func (printApplier) DeleteIssue(pk, id string) error {
+	fmt.Printf("%s: close deleted issue %s\n", pk, id)
+	return nil
+}
+
+func (printApplier) SyncComment(pk, id, cid, body string) error {
+	fmt.Printf("%s: update comment %s on issue %s\n", pk, cid, id)
+	return nil
+}
@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// jira webhook event names we act upon
+const (
+	issueCreated   = "jira:issue_created"
+	issueUpdated   = "jira:issue_updated"
+	issueDeleted   = "jira:issue_deleted"
+	commentCreated = "comment_created"
+	commentUpdated = "comment_updated"
+)
+
+// Event holds the parts of a jira webhook payload we need
+type Event struct {
+	Timestamp    int64         `json:"timestamp"`
+	WebhookEvent string        `json:"webhookEvent"`
+	Issue        *EventIssue   `json:"issue"`
+	Comment      *EventComment `json:"comment"`
+}
+
+// EventIssue is the issue a webhook event refers to
+type EventIssue struct {
+	ID     string `json:"id"`
+	Key    string `json:"key"`
+	Fields struct {
+		Project struct {
+			Key string `json:"key"`
+		} `json:"project"`
+	} `json:"fields"`
+}
+
+// EventComment is the comment a webhook event refers to
+type EventComment struct {
+	ID   string `json:"id"`
+	Body string `json:"body"`
+}
+
+// ParseEvent decodes a raw jira webhook payload
+func ParseEvent(b []byte) (*Event, error) {
+	e := &Event{}
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, fmt.Errorf("unable to parse webhook payload: %s", err)
+	}
+	if e.WebhookEvent == "" {
+		return nil, errors.New("payload is not a jira webhook event")
+	}
+	if e.Issue == nil || e.Issue.ID == "" {
+		return nil, fmt.Errorf("%s event without an issue", e.WebhookEvent)
+	}
+	return e, nil
+}
+
+// Project returns the key of the jira project the event belongs to
+func (e *Event) Project() string {
+	if e.Issue.Fields.Project.Key != "" {
+		return e.Issue.Fields.Project.Key
+	}
+	// older payloads don't carry the project, the issue key does
+	if i := strings.LastIndex(e.Issue.Key, "-"); i > 0 {
+		return e.Issue.Key[:i]
+	}
+	return ""
+}
@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const maxAttempts = 5
+const retryBackoff = 10 * time.Second
+
+// Queue is a durable fifo of webhook payloads. every payload is a file in the queue directory,
+// so events received before a crash or restart are processed once the daemon is back
+type Queue struct {
+	dir    string
+	notify chan struct{}
+
+	mu  sync.Mutex
+	seq int
+}
+
+// OpenQueue opens (and creates if needed) the queue in directory d
+func OpenQueue(d string) (*Queue, error) {
+	if err := os.MkdirAll(filepath.Join(d, "failed"), 0770); err != nil {
+		return nil, err
+	}
+	return &Queue{dir: d, notify: make(chan struct{}, 1)}, nil
+}
+
+// Push stores a payload at the end of the queue
+func (q *Queue) Push(b []byte) error {
+	q.mu.Lock()
+	q.seq = q.seq + 1
+	n := fmt.Sprintf("%020d-%06d.json", time.Now().UnixNano(), q.seq)
+	q.mu.Unlock()
+
+	// write next to the queue and rename, the worker should never see half a payload
+	tmp := filepath.Join(q.dir, n+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0640); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(q.dir, n)); err != nil {
+		return err
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// pending lists the queued payload files, oldest first
+func (q *Queue) pending() ([]string, error) {
+	fl, err := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(fl)
+	return fl, nil
+}
+
+// Drain processes everything currently in the queue with h. payloads h keeps failing on are moved aside
+// to the failed directory after maxAttempts so they don't block the events behind them
+func (q *Queue) Drain(h func([]byte) error) {
+	fl, err := q.pending()
+	if err != nil {
+		log.WithError(err).Error("unable to list queued events")
+		return
+	}
+
+	for _, f := range fl {
+		contextLogger := log.WithField("event", filepath.Base(f))
+
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			contextLogger.WithError(err).Error("unable to read queued event")
+			continue
+		}
+
+		for a := 1; ; a++ {
+			err = h(b)
+			if err == nil {
+				break
+			}
+			contextLogger.WithError(err).Errorf("unable to apply event (attempt %d of %d)", a, maxAttempts)
+			if a == maxAttempts {
+				break
+			}
+			time.Sleep(retryBackoff)
+		}
+
+		if err != nil {
+			contextLogger.Error("giving up on event, moving it to the failed queue")
+			os.Rename(f, filepath.Join(q.dir, "failed", filepath.Base(f)))
+			continue
+		}
+
+		if err := os.Remove(f); err != nil {
+			contextLogger.WithError(err).Error("unable to remove processed event")
+		}
+	}
+}
+
+// Run keeps draining the queue until stop is closed
+func (q *Queue) Run(h func([]byte) error, stop <-chan struct{}) {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+
+	for {
+		q.Drain(h)
+
+		select {
+		case <-stop:
+			return
+		case <-q.notify:
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package webhook
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/wianvos/pigmy/cmd/migrate"
+)
+
+var dryRun bool
+
+// create the command and add it to the syncCMD objects
+func addReplay() {
+	cmd := &cobra.Command{
+		Use:   "replay <payload.json>...",
+		Short: "apply recorded jira webhook payloads, in the given order",
+		Run:   runReplay,
+	}
+
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "only print what every payload would change")
+
+	syncCMD.AddCommand(cmd)
+}
+
+func runReplay(cmd *cobra.Command, args []string) {
+
+	contextLogger = contextLogger.WithField("subcommand", "Replay")
+
+	if len(args) == 0 {
+		contextLogger.Fatal("need at least one recorded payload to replay")
+		os.Exit(2)
+	}
+
+	var a applier = printApplier{}
+	if !dryRun {
+		if _, err := migrate.BeginRun("sync replay"); err != nil {
+			contextLogger.WithError(err).Error("unable to record the run")
+			fmt.Printf("unable to record the run: %s .. exiting\n", err)
+			os.Exit(2)
+		}
+		defer migrate.EndRun()
+		a = newGitlabApplier()
+	}
+	d := &dispatcher{a: a}
+
+	e := 0
+	for _, f := range args {
+		contextLogger := contextLogger.WithFields(log.Fields{"payload": f})
+
+		b, err := ioutil.ReadFile(f)
+		if err == nil {
+			_, err = ParseEvent(b)
+		}
+		if err == nil {
+			err = d.Handle(b)
+		}
+
+		if err != nil {
+			contextLogger.WithError(err).Error("unable to replay payload")
+			fmt.Printf("%s: %s\n", f, err)
+			e = e + 1
+		}
+	}
+
+	fmt.Printf("replayed %d payloads. %d errors encountered\n", len(args), e)
+	if e != 0 {
+		migrate.EndRun()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,152 @@
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/wianvos/pigmy/cmd/migrate"
+	"github.com/wianvos/pigmy/cmd/store"
+)
+
+// jira payloads carry the full issue, but nothing near this size
+const maxPayload = 10 << 20
+
+var listen string
+var hookPath string
+var secret string
+
+// create the command and add it to the syncCMD objects
+func addServe() {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "receive jira webhooks and apply them to the migrated gitlab projects",
+		Run:   runServe,
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", ":8080", "address to listen on for jira webhooks")
+	cmd.Flags().StringVar(&hookPath, "path", "/jira/webhook", "url path jira posts its webhooks to")
+	cmd.Flags().StringVar(&secret, "secret", "", "shared secret jira has to pass as the secret query parameter")
+
+	syncCMD.AddCommand(cmd)
+}
+
+func runServe(cmd *cobra.Command, args []string) {
+
+	contextLogger = contextLogger.WithField("subcommand", "Serve")
+
+	q, err := OpenQueue(queueDir())
+	if err != nil {
+		contextLogger.WithError(err).Error("unable to open the event queue")
+		fmt.Println("unable to open the event queue .. exiting")
+		os.Exit(2)
+	}
+
+	// the daemon runs for as long as the project is in use, users silenced by it would stay muted all that time
+	if viper.GetBool("quietNotifications") {
+		contextLogger.Warn("sync serve does not silence notifications, ignoring quietNotifications")
+		viper.Set("quietNotifications", false)
+	}
+
+	// serve shuts down by itself, the queued events have to be applied before the run ends
+	migrate.ExitOnSignal = false
+	if _, err := migrate.BeginRun("sync serve"); err != nil {
+		contextLogger.WithError(err).Error("unable to record the run")
+		fmt.Printf("unable to record the run: %s .. exiting\n", err)
+		os.Exit(2)
+	}
+
+	// events are queued by the http handler and applied one by one, in order, by the worker
+	d := &dispatcher{a: newGitlabApplier()}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		q.Run(d.Handle, stop)
+		close(stopped)
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle(hookPath, &Handler{Queue: q, Secret: secret})
+	srv := &http.Server{Addr: listen, Handler: mux}
+
+	// an interrupt stops taking webhooks, whatever was taken still gets applied
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-sc
+		contextLogger.Warnf("received %s, shutting down", s)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			contextLogger.WithError(err).Error("unable to shut down the webhook server")
+		}
+	}()
+
+	fmt.Printf("listening for jira webhooks on %s%s\n", listen, hookPath)
+	err = srv.ListenAndServe()
+
+	close(stop)
+	<-stopped
+	q.Drain(d.Handle)
+	migrate.EndRun()
+
+	if err != http.ErrServerClosed {
+		contextLogger.WithError(err).Error("webhook server stopped")
+		fmt.Println(err)
+		os.Exit(2)
+	}
+}
+
+// queueDir returns the directory holding the durable event queue
+func queueDir() string {
+	return filepath.Join(store.Dir(), "queue")
+}
+
+// Handler receives jira webhooks and stores them in the queue
+type Handler struct {
+	Queue  *Queue
+	Secret string
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.Secret != "" && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("secret")), []byte(h.Secret)) != 1 {
+		contextLogger.Warn("rejected webhook with invalid secret")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxPayload))
+	if err != nil {
+		http.Error(w, "unable to read payload", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := ParseEvent(b); err != nil {
+		contextLogger.WithError(err).Warn("rejected webhook")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Queue.Push(b); err != nil {
+		contextLogger.WithError(err).Error("unable to queue webhook")
+		http.Error(w, "unable to queue event", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
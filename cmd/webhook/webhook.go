@@ -0,0 +1,27 @@
+package webhook
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var syncCMD = &cobra.Command{
+	Use:   "sync",
+	Short: "mirror ongoing jira activity into gitlab",
+}
+
+var contextLogger = log.WithFields(log.Fields{"Command": "Sync"})
+
+// jira projects to sync, empty means every migrated project
+var projects []string
+
+//GetCommands grab and return commands in this package
+func GetCommands() *cobra.Command {
+
+	syncCMD.PersistentFlags().StringSliceVar(&projects, "projects", nil, "only sync these jira projects (comma separated, defaults to every migrated project)")
+
+	//collect the commands in the package
+	addServe()
+	addReplay()
+	return syncCMD
+}
@@ -0,0 +1,325 @@
+// Package pigmy runs jira to gitlab migrations from go programs, without shelling out to the command line.
+//
+// The migration keeps process wide state: the jira and gitlab clients, the settings and the run journal.
+// A process runs one migration at a time, Migrate and Sync wait for the one in progress to finish
+// or for their context to be done. The configuration of a migrator is in effect for the duration of
+// its migration only: the settings, clients, logger and options it replaced are put back afterwards and
+// what the migration cached is forgotten. Its progress goes to the logger.
+package pigmy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	jira "github.com/wianvos/go-jira"
+	"github.com/wianvos/pigmy/cmd/migrate"
+	"github.com/wianvos/pigmy/cmd/store"
+	utils "github.com/wianvos/pigmy/cmd/utils"
+	gitlab "github.com/xanzy/go-gitlab"
+)
+
+// ProjectConfig holds the settings of a single project migration
+type ProjectConfig = migrate.ProjectConfig
+
+// MembershipConfig maps jira project roles and permissions to gitlab access levels
+type MembershipConfig = migrate.MembershipConfig
+
+// WriteBackOptions decide what is written back to the migrated jira issues
+type WriteBackOptions = migrate.WriteBackOptions
+
+// Config is everything a migrator needs to know, in place of the command line flags and config file
+type Config struct {
+	JiraURL      string
+	JiraUsername string
+	JiraPassword string
+	GitlabURL    string
+	GitlabToken  string
+	// Jira and Gitlab are used instead of clients built from the urls and credentials above.
+	// the jira url is still needed to link to jira issues, the gitlab token for impersonation tokens
+	Jira   *jira.Client
+	Gitlab *gitlab.Client
+	// StateDir holds the migration state and run journals, defaults to ./state
+	StateDir string
+	// TmpDir holds attachements while they are on their way, defaults to ./tmp
+	TmpDir string
+	// Incremental only migrates the issues updated since the last successful migration of a project
+	Incremental bool
+	// WriteBack points the migrated jira issues to their new home
+	WriteBack WriteBackOptions
+	// GithubArchive is the github migration archive to migrate projects from instead of jira,
+	// for the projects that don't name one themselves
+	GithubArchive string
+	// Settings holds any other setting under its config file key: authorship, quietNotifications,
+	// target, export, giteaURL, ...
+	Settings map[string]interface{}
+	// Logger receives the log, the standard logrus logger when nil
+	Logger *log.Logger
+}
+
+// Event is an action a migration took on a single object, with its outcome
+type Event struct {
+	Time time.Time
+	Run  string
+	// Action is create, update, skip, close, writeback or retry
+	Action string
+	// Kind is the kind of object acted on: project, issue, note, label, member, user, jira-issue, ...
+	Kind     string
+	Failed   bool
+	Error    string
+	Duration time.Duration
+	// the object in gitlab
+	ProjectID int
+	IssueIID  int
+	ID        int
+	Name      string
+	// the object in jira
+	JiraProject string
+	JiraKey     string
+}
+
+// Result sums up the migration of a project
+type Result struct {
+	Run      string
+	Project  string
+	Migrated int
+	Failed   int
+	Duration time.Duration
+}
+
+// Migrator migrates projects with an explicit configuration
+type Migrator struct {
+	cfg Config
+
+	mu       sync.Mutex
+	handlers []func(Event)
+}
+
+// running makes migrations in one process wait for each other, it holds the migration in progress
+var running = make(chan struct{}, 1)
+
+// New returns a migrator for configuration c
+func New(c Config) (*Migrator, error) {
+	if c.Jira == nil && c.JiraURL == "" && c.Settings["export"] == nil && c.GithubArchive == "" {
+		return nil, fmt.Errorf("no jira, export or github archive configured")
+	}
+	t, _ := c.Settings["target"].(string)
+	if (t == "" || t == "gitlab") && c.Gitlab == nil && c.GitlabURL == "" {
+		return nil, fmt.Errorf("no gitlab configured")
+	}
+	if c.StateDir == "" {
+		c.StateDir = "./state"
+	}
+	if c.TmpDir == "" {
+		c.TmpDir = "./tmp"
+	}
+	return &Migrator{cfg: c}, nil
+}
+
+// OnEvent has f called with every action migrations of m take. f is called from the goroutine
+// doing the migration, it shouldn't take long
+func (m *Migrator) OnEvent(f func(Event)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = append(m.handlers, f)
+}
+
+// Migrate migrates project c. once ctx is done the migration stops between issues and returns its error,
+// what was migrated until then is recorded and a later migration picks up from there
+func (m *Migrator) Migrate(ctx context.Context, c ProjectConfig) (*Result, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("no project name")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if c.GithubArchive == "" {
+		c.GithubArchive = m.cfg.GithubArchive
+	}
+
+	r, end, err := m.begin(ctx, "migrate project "+c.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+
+	res := &Result{Run: r.ID, Project: c.Name}
+	start := time.Now()
+	p, err := migrate.FetchProject(ctx, c)
+	if err == nil {
+		err = p.MigrateProject()
+	}
+	if err == nil {
+		p.WriteBackIfEnabled()
+	}
+	res.Migrated = p.Migrated
+	res.Failed = p.Failed
+	res.Duration = time.Since(start)
+	return res, err
+}
+
+// Sync creates or updates the gitlab counterpart of jira issue id of already migrated project pn
+func (m *Migrator) Sync(ctx context.Context, pn, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	_, end, err := m.begin(ctx, "sync "+pn)
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	p, err := migrate.LoadProject(pn)
+	if err != nil {
+		return err
+	}
+	return p.SyncIssue(id)
+}
+
+// begin waits for the migration in progress, unless ctx is done first, applies the configuration and
+// starts a run. end finishes the run and lets the next migration go
+func (m *Migrator) begin(ctx context.Context, c string) (*store.Run, func(), error) {
+	select {
+	case running <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+
+	restore := m.apply()
+	r, err := migrate.BeginRun(c)
+	if err != nil {
+		restore()
+		<-running
+		return nil, nil, err
+	}
+	stop := migrate.Observe(m.dispatch)
+
+	return r, func() {
+		stop()
+		migrate.EndRun()
+		restore()
+		<-running
+	}, nil
+}
+
+// apply hands the configuration to the migrate package, which reads it from viper and the utils clients.
+// restore puts back what was there before and has the package forget what it cached, so nothing carries
+// over to the next migrator
+func (m *Migrator) apply() (restore func()) {
+	c := m.cfg
+
+	settings := map[string]interface{}{
+		"jiraURL":             c.JiraURL,
+		"jiraAccountUsername": c.JiraUsername,
+		"jiraAccountPassword": c.JiraPassword,
+		"gitlabURL":           c.GitlabURL,
+		"gitlabToken":         c.GitlabToken,
+		"stateDir":            c.StateDir,
+		"localTmpDir":         c.TmpDir,
+	}
+	for k, v := range c.Settings {
+		settings[k] = v
+	}
+	if c.Gitlab != nil && c.GitlabURL == "" {
+		settings["gitlabURL"] = c.Gitlab.BaseURL().String()
+	}
+
+	prev := make(map[string]interface{}, len(settings))
+	for k, v := range settings {
+		prev[k] = viper.Get(k)
+		viper.Set(k, v)
+	}
+
+	// clients of an earlier migrator must not linger
+	jc, gc := utils.JiraClient, utils.GitlabClient
+	utils.JiraClient = c.Jira
+	utils.GitlabClient = c.Gitlab
+	migrate.Reset()
+
+	inc := migrate.SetIncremental(c.Incremental)
+	wb := migrate.SetWriteBack(c.WriteBack)
+	exit := migrate.ExitOnSignal
+	migrate.ExitOnSignal = false
+	l := c.Logger
+	if l == nil {
+		l = log.StandardLogger()
+	}
+	pl := migrate.SetLogger(l)
+	w := &logWriter{l: l}
+	po := migrate.SetOutput(w)
+
+	return func() {
+		w.flush()
+		migrate.SetOutput(po)
+		migrate.SetLogger(pl)
+		migrate.ExitOnSignal = exit
+		migrate.SetWriteBack(wb)
+		migrate.SetIncremental(inc)
+		migrate.Reset()
+		utils.JiraClient, utils.GitlabClient = jc, gc
+		for k, v := range prev {
+			viper.Set(k, v)
+		}
+	}
+}
+
+// logWriter logs what the migrate package reports, a line at a time
+type logWriter struct {
+	l   *log.Logger
+	buf []byte
+}
+
+func (w *logWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		x := bytes.IndexByte(w.buf, '\n')
+		if x == -1 {
+			return len(b), nil
+		}
+		w.log(w.buf[:x])
+		w.buf = w.buf[x+1:]
+	}
+}
+
+// flush logs the last line, when it did not end
+func (w *logWriter) flush() {
+	w.log(w.buf)
+	w.buf = nil
+}
+
+func (w *logWriter) log(b []byte) {
+	if t := string(bytes.TrimSpace(b)); t != "" {
+		w.l.WithField("Command", "Migrate").Info(t)
+	}
+}
+
+func (m *Migrator) dispatch(e *store.Entry) {
+	ev := Event{
+		Time:        e.Time,
+		Run:         e.Run,
+		Action:      e.Action,
+		Kind:        e.Kind,
+		Failed:      e.Result == store.ResultFailed,
+		Error:       e.Error,
+		Duration:    time.Duration(e.DurationMS) * time.Millisecond,
+		ProjectID:   e.ProjectID,
+		IssueIID:    e.IssueIID,
+		ID:          e.ID,
+		Name:        e.Name,
+		JiraProject: e.JiraProject,
+		JiraKey:     e.JiraKey,
+	}
+
+	m.mu.Lock()
+	hs := m.handlers
+	m.mu.Unlock()
+	for _, h := range hs {
+		h(ev)
+	}
+}
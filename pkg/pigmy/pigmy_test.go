@@ -0,0 +1,78 @@
+package pigmy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/wianvos/pigmy/cmd/migrate"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		c    Config
+		ok   bool
+	}{
+		{"jira and gitlab", Config{JiraURL: "https://jira", GitlabURL: "https://gitlab"}, true},
+		{"github archive", Config{GithubArchive: "archive.tar.gz", GitlabURL: "https://gitlab"}, true},
+		{"export", Config{Settings: map[string]interface{}{"export": "export.xml"}, GitlabURL: "https://gitlab"}, true},
+		{"no source", Config{GitlabURL: "https://gitlab"}, false},
+		{"no gitlab", Config{JiraURL: "https://jira"}, false},
+		{"archive target", Config{JiraURL: "https://jira", Settings: map[string]interface{}{"target": "archive"}}, true},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.c); (err == nil) != tt.ok {
+			t.Errorf("%s: New returned %v", tt.name, err)
+		}
+	}
+}
+
+func TestApplyRestores(t *testing.T) {
+	viper.Set("authorship", "header")
+	viper.Set("gitlabURL", "https://before")
+	defer viper.Set("authorship", nil)
+	defer viper.Set("gitlabURL", nil)
+	l := log.New()
+	migrate.SetLogger(l)
+
+	m, err := New(Config{JiraURL: "https://jira", GitlabURL: "https://gitlab", Incremental: true, Settings: map[string]interface{}{"authorship": "sudo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restore := m.apply()
+	if viper.GetString("authorship") != "sudo" || viper.GetString("gitlabURL") != "https://gitlab" || migrate.ExitOnSignal {
+		t.Error("the configuration was not applied")
+	}
+	restore()
+
+	if viper.GetString("authorship") != "header" || viper.GetString("gitlabURL") != "https://before" {
+		t.Errorf("settings not restored: %s %s", viper.GetString("authorship"), viper.GetString("gitlabURL"))
+	}
+	if !migrate.ExitOnSignal {
+		t.Error("exit on signal not restored")
+	}
+	if migrate.SetIncremental(false) {
+		t.Error("incremental not restored")
+	}
+	if migrate.SetLogger(l) != l {
+		t.Error("logger not restored")
+	}
+}
+
+func TestBeginWaitsForContext(t *testing.T) {
+	running <- struct{}{}
+	defer func() { <-running }()
+
+	m, err := New(Config{JiraURL: "https://jira", GitlabURL: "https://gitlab"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := m.begin(ctx, "test"); err != context.DeadlineExceeded {
+		t.Errorf("begin returned %v while another migration runs", err)
+	}
+}